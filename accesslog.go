@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logRedactRegexp matches the names of query parameters whose values are left
+// out of logs, set from -log-redact.
+var logRedactRegexp *regexp.Regexp
+
+// loggedRequests counts successful requests, for sampling.
+var loggedRequests atomic.Uint64
+
+func setupLogRedaction(patterns string) error {
+	var alternatives []string
+	for _, pattern := range splitList(patterns) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%q: %w", pattern, err)
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	if len(alternatives) > 0 {
+		logRedactRegexp = regexp.MustCompile("(?i)" + strings.Join(alternatives, "|"))
+	}
+	return nil
+}
+
+// redactQuery returns a query string fit for logs, with the values of
+// parameters matching -log-redact replaced.
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" || logRedactRegexp == nil {
+		return rawQuery
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "(invalid)"
+	}
+	for name, values := range query {
+		if logRedactRegexp.MatchString(name) {
+			for i := range values {
+				values[i] = "REDACTED"
+			}
+		}
+	}
+	return query.Encode()
+}
+
+// logRequest writes the access log of an answered request: all failures, and
+// one in -log-sample successful requests.
+func logRequest(w http.ResponseWriter, r *http.Request, start time.Time, args []any) {
+	var status, bytes = http.StatusOK, int64(0)
+	if rec, ok := w.(*responseRecorder); ok {
+		status, bytes = rec.status, rec.bytes
+	}
+	var level = slog.LevelInfo
+	if status >= http.StatusBadRequest {
+		level = slog.LevelWarn
+	} else if *logSample > 1 {
+		if loggedRequests.Add(1)%uint64(*logSample) != 0 {
+			return
+		}
+		args = append(args, "sample", *logSample)
+	}
+	if r.URL.RawQuery != "" {
+		args = append(args, "query", redactQuery(r.URL.RawQuery))
+	}
+	args = append(args, "status", status, "bytes", bytes, "duration", time.Since(start))
+	slog.Log(r.Context(), level, "request", args...)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// handleArchive answers ?archive=tar.gz on a directory.
+func handleArchive(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint) {
+	if r.URL.Query().Get("archive") != "tar.gz" {
+		slog.Warn("unsupported archive format", "path", r.URL.Path, "archive", r.URL.Query().Get("archive"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if mountPoint == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	options, err := listOptionsFor(r.URL.Query(), mountPoint)
+	if err != nil {
+		slog.Warn("invalid listing options", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": listingFilename(r.URL.Path, "tar.gz")}))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	streamTarGz(w, r, mountPoint, options)
+}
+
+// streamTarGz writes all the objects under a directory as a gzipped tarball,
+// one object at a time as they come off the GCS iterator, so that neither the
+// listing nor the objects have to fit in memory. Objects stored with gzip
+// encoding are archived decompressed, as they would be downloaded, through a
+// temporary file. Nested mount points, marked directories and objects whose
+// path the OIDC rules forbid to the user are left out.
+func streamTarGz(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint, options ListOptions) {
+	ctx, span := tracer.Start(r.Context(), "streamTarGz")
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var compressed = gzip.NewWriter(w)
+	var archive = tar.NewWriter(compressed)
+	var bucket = client.Bucket(mountPoint.Bucket)
+	var failed error
+	var write = func(item Item) {
+		if failed != nil || item.Dir || strings.HasSuffix(item.Name, "/") {
+			return
+		}
+		if !pathAllowed(r, mountPoint, r.URL.Path+item.Name) {
+			// Claim rules for subdirectories apply as if they were requested.
+			slog.Warn("forbidden object left out of archive", "path", r.URL.Path+item.Name, "user", requestUser(r))
+			return
+		}
+		var obj = bucket.Object(mountPoint.ObjectName(r.URL.Path + item.Name)).Generation(item.generation)
+		failed = func() error {
+			reader, err := obj.NewReader(ctx)
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			var content io.Reader = reader
+			var size = reader.Attrs.Size
+			if size < 0 {
+				// Decompressed by GCS, the size is only known once read.
+				spool, err := os.CreateTemp("", "gcs-index-archive-")
+				if err != nil {
+					return err
+				}
+				defer os.Remove(spool.Name())
+				defer spool.Close()
+				if size, err = io.Copy(spool, reader); err != nil {
+					return err
+				}
+				if _, err := spool.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				content = spool
+			}
+
+			err = archive.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     item.Name,
+				Size:     size,
+				Mode:     0644,
+				ModTime:  *item.Updated,
+				Format:   tar.FormatPAX,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(archive, content)
+			return err
+		}()
+		if failed != nil {
+			failed = fmt.Errorf("%s: %w", item.Name, failed)
+			cancel()
+		}
+	}
+
+	options.Recursive = true
+	slog.Info("streaming archive", "path", r.URL.Path)
+	if _, _, err := walkStorage(ctx, mountPoint, r.URL.Path, options, 0, write); err != nil && failed == nil {
+		failed = err
+	}
+	if failed != nil {
+		// Leaving the tarball unterminated lets clients notice the failure.
+		span.RecordError(failed)
+		slog.Error("archive aborted", "path", r.URL.Path, "err", failed)
+		return
+	}
+	archive.Close()
+	compressed.Close()
+}
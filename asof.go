@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/iterator"
+)
+
+// parseAsOf reads ?asOf=, either a RFC 3339 time or a date at midnight UTC.
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// asOfQuery is the query string of the links to the entries of listings as of
+// a past time: objects are served in the generation listed, and subdirectories
+// listed as of the same time.
+func (listing *Listing) asOfQuery(item Item) string {
+	switch {
+	case listing.AsOf == nil:
+		return ""
+	case item.Dir:
+		return "?asOf=" + url.QueryEscape(listing.AsOf.UTC().Format(time.RFC3339))
+	default:
+		return "?generation=" + strconv.FormatInt(item.generation, 10)
+	}
+}
+
+// walkVersions is walkStorage for listings as of a past time: it goes through
+// all the generations of the objects of a directory and passes the one that
+// was live at that time to yield, if any. Subdirectories are passed as long
+// as they hold any generation. Only buckets with object versioning keep the
+// generations of overwritten and deleted objects.
+func walkVersions(ctx context.Context, mountPoint *MountPoint, path string, options ListOptions, limit int, yield func(Item)) (next string, err error) {
+	var count int
+	query := &storage.Query{
+		Prefix:    mountPoint.ObjectName(path),
+		Delimiter: "/",
+		Versions:  true,
+	}
+	var marked []string
+	if options.Recursive {
+		query.Delimiter = ""
+		if marked, err = noIndexDirs(ctx, client.Bucket(mountPoint.Bucket), query.Prefix); err != nil {
+			return "", err
+		}
+	}
+	if options.Start != "" {
+		query.StartOffset = query.Prefix + options.Start
+	}
+
+	slog.Debug("listing object generations", "bucket", mountPoint.Bucket, "query", query, "asOf", options.AsOf)
+
+	defer observeListing(mountPoint, time.Now())
+
+	ctx, span := tracer.Start(ctx, "storage.Objects", trace.WithAttributes(
+		attribute.String("gcs.bucket", mountPoint.Bucket),
+		attribute.String("gcs.prefix", query.Prefix),
+		attribute.Bool("gcs.versions", true),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("gcs.items", count))
+		span.End()
+	}()
+
+	// Generations of an object come together, the live one is only known
+	// once the next name shows up.
+	var live *storage.ObjectAttrs
+	var flush = func() {
+		if live == nil {
+			return
+		}
+		if status := parseStatus(live.Metadata); !options.NoYanked || !hasStatus(status, yankedStatus) {
+			count++
+			yield(Item{
+				Name:         strings.TrimPrefix(live.Name, query.Prefix),
+				Size:         live.Size,
+				Updated:      &live.Updated,
+				MD5:          fmt.Sprintf("%x", live.MD5),
+				ContentType:  live.ContentType,
+				Status:       status,
+				StorageClass: live.StorageClass,
+				CustomTime:   customTime(live),
+				generation:   live.Generation,
+			})
+		}
+		live = nil
+	}
+
+	var last string
+	objects := client.Bucket(mountPoint.Bucket).Objects(ctx, query)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", ctxErr
+			}
+			span.RecordError(err)
+			slog.Error("failed to list object generations", "err", err)
+			return "", err
+		}
+
+		var name = strings.TrimPrefix(attrs.Name+attrs.Prefix, query.Prefix)
+		if name != last {
+			flush()
+			if limit > 0 && count >= limit {
+				return name, nil
+			}
+			last = name
+		}
+		if mountPoint.SkipReadme && strings.ToLower(name) == "readme.md" {
+			continue
+		}
+		if mountPoint.isStaged(attrs.Name+attrs.Prefix) || !options.match(name) || (attrs.Name != "" && !options.filter(name)) || (options.Stable && isPrerelease(name)) || inNoIndexDir(name, marked) {
+			continue
+		}
+
+		if attrs.Prefix != "" {
+			count++
+			yield(Item{Name: name, Dir: true})
+		} else if attrs.Name != query.Prefix && !attrs.Created.After(options.AsOf) && (attrs.Deleted.IsZero() || attrs.Deleted.After(options.AsOf)) {
+			live = attrs
+		}
+	}
+	flush()
+	return "", nil
+}
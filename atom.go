@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const maxFeedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+var atomFormat = ListingFormat{ContentType: "application/atom+xml", Render: renderAtom}
+
+// renderAtom lists the most recent objects of the page as an Atom feed, so
+// that new objects can be followed in feed readers.
+func renderAtom(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+	var items = slices.DeleteFunc(slices.Clone(listing.Items), func(item Item) bool { return item.Dir })
+	slices.SortStableFunc(items, itemComparator("time", true, strings.Compare))
+	items = items[:min(len(items), maxFeedEntries)]
+
+	var url = listing.links.Absolute(listing.Path)
+	var feed = atomFeed{
+		Title:   listing.Path,
+		ID:      url,
+		Updated: listing.IndexedAt.Format(time.RFC3339),
+		Author:  "gcs-index",
+		Links:   []atomLink{{Rel: "self", Href: url + "?format=atom"}, {Href: url}},
+	}
+	if len(items) > 0 {
+		feed.Updated = items[0].Updated.UTC().Format(time.RFC3339)
+	}
+	for _, item := range items {
+		var itemURL = listing.links.Absolute(listing.Path+item.Name) + listing.asOfQuery(item)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title: item.Name,
+			// Entries change identity when the object is replaced.
+			ID:      fmt.Sprintf("%s#%d", itemURL, item.Updated.UnixNano()),
+			Updated: item.Updated.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: itemURL},
+			Summary: fmt.Sprintf("%s, %s", item.Name, humanize.IBytes(uint64(item.Size))),
+		})
+	}
+
+	w.WriteString(xml.Header)
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		slog.Error("failed to encode feed", "err", err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth protects a mount point with HTTP Basic authentication. Passwords
+// use the htpasswd formats: bcrypt ($2y$...), SHA1 ({SHA}...) or plain text.
+type BasicAuth struct {
+	realm string
+	users map[string]string
+
+	verified sync.Map // Hashes of credentials already checked against bcrypt.
+}
+
+func newBasicAuth(realm string, users map[string]string, htpasswd string) (*BasicAuth, error) {
+	var auth = &BasicAuth{realm: realm, users: make(map[string]string)}
+	if auth.realm == "" {
+		auth.realm = "gcs-index"
+	}
+
+	for user, password := range users {
+		auth.users[user] = password
+	}
+
+	if htpasswd != "" {
+		file, err := os.Open(htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			entry := strings.TrimSpace(scanner.Text())
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+			user, password, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: expected 'user:password'", htpasswd, line)
+			}
+			auth.users[user] = password
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for user, password := range auth.users {
+		if strings.HasPrefix(password, "$apr1$") || strings.HasPrefix(password, "$1$") {
+			return nil, fmt.Errorf("user %q: MD5 password hashes are not supported, use bcrypt", user)
+		}
+	}
+
+	if len(auth.users) == 0 {
+		return nil, errors.New("basic auth without any user")
+	}
+	return auth, nil
+}
+
+// Check returns the authenticated user, or an empty string.
+func (a *BasicAuth) Check(r *http.Request) string {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+	expected, ok := a.users[user]
+	if !ok {
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(expected, "$2"):
+		// bcrypt is slow by design, remember successful attempts.
+		sum := sha256.Sum256([]byte(user + ":" + password + ":" + expected))
+		if _, ok := a.verified.Load(sum); ok {
+			return user
+		}
+		if bcrypt.CompareHashAndPassword([]byte(expected), []byte(password)) != nil {
+			return ""
+		}
+		a.verified.Store(sum, struct{}{})
+	case strings.HasPrefix(expected, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		if subtle.ConstantTimeCompare([]byte(expected[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) != 1 {
+			return ""
+		}
+	default:
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+			return ""
+		}
+	}
+	return user
+}
+
+func (a *BasicAuth) challenge() string {
+	return fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", a.realm)
+}
+
+type userKey struct{}
+
+// withUser records the authenticated user in the request context.
+func withUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+}
+
+// requestUser returns the authenticated user, or an empty string.
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// authenticate checks the credentials required by the mount point, if any,
+// and returns the request along with the authenticated user. Bearer tokens go
+// to OIDC and anything else to Basic auth; the request is answered with the
+// relevant challenges when credentials are missing or invalid.
+func authenticate(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint) (*http.Request, bool) {
+	if mountPoint.BasicAuth == nil && mountPoint.OIDCAuth == nil {
+		return r, true
+	}
+
+	var user string
+	var claims map[string]any
+	var invalidToken bool
+	if token, ok := bearerToken(r); ok && mountPoint.OIDCAuth != nil {
+		var err error
+		user, claims, err = mountPoint.OIDCAuth.Check(r.Context(), token, r.URL.Path)
+		if errors.Is(err, errForbidden) {
+			slog.Warn("forbidden", "path", r.URL.Path, "user", user)
+			w.WriteHeader(http.StatusForbidden)
+			return r, false
+		} else if err != nil {
+			slog.Warn("invalid bearer token", "path", r.URL.Path, "err", err)
+			user, invalidToken = "", true
+		}
+	} else if mountPoint.BasicAuth != nil {
+		user = mountPoint.BasicAuth.Check(r)
+	}
+
+	if user == "" {
+		slog.Warn("unauthorized", "path", r.URL.Path)
+		if mountPoint.OIDCAuth != nil {
+			w.Header().Add("WWW-Authenticate", mountPoint.OIDCAuth.challenge(invalidToken))
+		}
+		if mountPoint.BasicAuth != nil {
+			w.Header().Add("WWW-Authenticate", mountPoint.BasicAuth.challenge())
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return r, false
+	}
+
+	slog.Debug("authenticated", "user", user)
+	if claims != nil {
+		r = withClaims(r, claims)
+	}
+	return withUser(r, user), true
+}
+
+// privateResponse keeps shared caches from storing the responses to
+// authenticated requests, which carry the Cache-Control of their mount point.
+type privateResponse struct {
+	http.ResponseWriter
+	vary        []string // Headers holding the credentials.
+	wroteHeader bool
+}
+
+func (p *privateResponse) WriteHeader(status int) {
+	if !p.wroteHeader {
+		p.wroteHeader = true
+		var h = p.Header()
+		h.Set("Cache-Control", privateCacheControl(h.Get("Cache-Control")))
+		for _, header := range p.vary {
+			h.Add("Vary", header)
+		}
+	}
+	p.ResponseWriter.WriteHeader(status)
+}
+
+func (p *privateResponse) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (p *privateResponse) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
+
+// privateCacheControl turns a Cache-Control value into one for the browser
+// cache only, keeping its lifetime.
+func privateCacheControl(value string) string {
+	var directives = []string{"private"}
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		name, _, _ := strings.Cut(strings.ToLower(directive), "=")
+		switch name {
+		case "no-store":
+			return "no-store"
+		case "", "public", "private", "s-maxage", "proxy-revalidate":
+			continue
+		}
+		directives = append(directives, directive)
+	}
+	return strings.Join(directives, ", ")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// chaosOperations are the kinds of GCS requests faults are injected into.
+var chaosOperations = []string{"all", "attrs", "list", "read", "write"}
+
+// chaosFault is injected into the GCS requests of an operation: each waits
+// for the latency, then fails with a 503 at the error rate.
+type chaosFault struct {
+	latency   time.Duration
+	errorRate float64
+}
+
+// parseChaos reads the faults given with -chaos, as a comma-separated list
+// of operation:latency:error-rate, e.g. list:2s:0.1,read:0s:0.05.
+func parseChaos(spec string) (map[string]chaosFault, error) {
+	var faults = make(map[string]chaosFault)
+	for _, item := range splitList(spec) {
+		var parts = strings.Split(item, ":")
+		if len(parts) != 3 || !slices.Contains(chaosOperations, parts[0]) {
+			return nil, fmt.Errorf("%q: expected operation:latency:error-rate, operations being %s", item, strings.Join(chaosOperations, ", "))
+		}
+		latency, err := time.ParseDuration(parts[1])
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("%q: invalid latency", item)
+		}
+		errorRate, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || errorRate < 0 || errorRate > 1 {
+			return nil, fmt.Errorf("%q: invalid error rate", item)
+		}
+		faults[parts[0]] = chaosFault{latency, errorRate}
+	}
+	return faults, nil
+}
+
+// storageClientOptions returns the options of the storage client, which go
+// through a chaosTransport with -chaos. Objects can't be signed then.
+func storageClientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	var options = []option.ClientOption{storage.WithJSONReads()}
+	if *chaos == "" {
+		return options, nil
+	}
+	faults, err := parseChaos(*chaos)
+	if err != nil {
+		return nil, err
+	}
+	slog.Warn("injecting faults into GCS requests", "chaos", *chaos)
+
+	var transport http.RoundTripper = &chaosTransport{base: http.DefaultTransport, faults: faults}
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		if transport, err = htransport.NewTransport(ctx, transport, option.WithScopes(storage.ScopeFullControl)); err != nil {
+			return nil, err
+		}
+	}
+	return append(options, option.WithHTTPClient(&http.Client{Transport: transport})), nil
+}
+
+type chaosTransport struct {
+	base   http.RoundTripper
+	faults map[string]chaosFault
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var operation = chaosOperation(req)
+	fault, ok := t.faults[operation]
+	if !ok {
+		if fault, ok = t.faults["all"]; !ok {
+			return t.base.RoundTrip(req)
+		}
+	}
+
+	if fault.latency > 0 {
+		select {
+		case <-time.After(fault.latency):
+		case <-req.Context().Done():
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+	}
+	if rand.Float64() >= fault.errorRate {
+		return t.base.RoundTrip(req)
+	}
+
+	slog.Debug("injecting GCS error", "operation", operation, "method", req.Method, "url", req.URL.Redacted())
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return &http.Response{
+		Status:     "503 Service Unavailable",
+		StatusCode: http.StatusServiceUnavailable,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"error":{"code":503,"message":"injected by -chaos"}}`)),
+		Request:    req,
+	}, nil
+}
+
+// chaosOperation tells the kind of a request of the GCS JSON API.
+func chaosOperation(req *http.Request) string {
+	switch {
+	case req.Method != http.MethodGet && req.Method != http.MethodHead:
+		return "write"
+	case strings.HasPrefix(req.URL.Path, "/download/") || req.URL.Query().Get("alt") == "media":
+		return "read"
+	case strings.HasSuffix(req.URL.Path, "/o"):
+		return "list"
+	default:
+		return "attrs"
+	}
+}
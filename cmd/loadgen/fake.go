@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const fakePageSize = 1000
+
+// fakeBackend serves a minimal subset of the GCS JSON API (list, attributes
+// and media download) over a synthetic, read-only bucket. Point gcs-index at
+// it with STORAGE_EMULATOR_HOST.
+type fakeBackend struct {
+	bucket  string
+	names   []string
+	content []byte
+	md5     string
+	updated time.Time
+}
+
+type fakeObject struct {
+	Kind        string `json:"kind"`
+	Bucket      string `json:"bucket"`
+	Name        string `json:"name"`
+	Size        string `json:"size"`
+	ContentType string `json:"contentType"`
+	Md5Hash     string `json:"md5Hash"`
+	Etag        string `json:"etag"`
+	Generation  string `json:"generation"`
+	Updated     string `json:"updated"`
+}
+
+type fakeList struct {
+	Kind          string       `json:"kind"`
+	Items         []fakeObject `json:"items,omitempty"`
+	Prefixes      []string     `json:"prefixes,omitempty"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+func newFakeBackend(bucket string, dirs, objects, size int) *fakeBackend {
+	content := bytes.Repeat([]byte("gcs-index "), size/10+1)[:size]
+	sum := md5.Sum(content)
+
+	var names []string
+	for d := 0; d < dirs; d++ {
+		for o := 0; o < objects; o++ {
+			names = append(names, fmt.Sprintf("dir-%03d/build-%d.%d.%d.tar.gz", d, o/100, o/10%10, o%10))
+		}
+		names = append(names, fmt.Sprintf("dir-%03d/README.md", d))
+	}
+	slices.Sort(names)
+
+	return &fakeBackend{
+		bucket:  bucket,
+		names:   names,
+		content: content,
+		md5:     base64.StdEncoding.EncodeToString(sum[:]),
+		updated: time.Now().Add(-time.Hour).UTC(),
+	}
+}
+
+func (f *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/download")
+	path, ok := strings.CutPrefix(path, "/storage/v1/b/"+f.bucket+"/o")
+	if !ok {
+		slog.Debug("fake backend: unexpected request", "path", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+
+	if path == "" || path == "/" {
+		f.list(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(path, "/")
+	if _, found := slices.BinarySearch(f.names, name); !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("alt") == "media" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(f.content)))
+		w.Header().Set("X-Goog-Generation", "1")
+		w.Write(f.content)
+		return
+	}
+
+	writeJSON(w, f.object(name))
+}
+
+func (f *fakeBackend) list(w http.ResponseWriter, r *http.Request) {
+	var query = r.URL.Query()
+	var prefix = query.Get("prefix")
+	var delimiter = query.Get("delimiter")
+	var offset, _ = strconv.Atoi(query.Get("pageToken"))
+
+	// Collapse names into (object or prefix) entries, in lexicographic order.
+	var entries []string
+	var isPrefix = make(map[string]bool)
+	start, _ := slices.BinarySearch(f.names, max(prefix, query.Get("startOffset")))
+	for _, name := range f.names[start:] {
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				name = name[:len(prefix)+i+len(delimiter)]
+				if isPrefix[name] {
+					continue
+				}
+				isPrefix[name] = true
+			}
+		}
+		entries = append(entries, name)
+	}
+
+	var result = fakeList{Kind: "storage#objects"}
+	end := min(offset+fakePageSize, len(entries))
+	for _, name := range entries[min(offset, end):end] {
+		if isPrefix[name] {
+			result.Prefixes = append(result.Prefixes, name)
+		} else {
+			result.Items = append(result.Items, f.object(name))
+		}
+	}
+	if end < len(entries) {
+		result.NextPageToken = strconv.Itoa(end)
+	}
+
+	writeJSON(w, result)
+}
+
+func (f *fakeBackend) object(name string) fakeObject {
+	return fakeObject{
+		Kind:        "storage#object",
+		Bucket:      f.bucket,
+		Name:        name,
+		Size:        strconv.Itoa(len(f.content)),
+		ContentType: "application/octet-stream",
+		Md5Hash:     f.md5,
+		Etag:        "CAE=",
+		Generation:  "1",
+		Updated:     f.updated.Format(time.RFC3339Nano),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("fake backend: failed to encode response", "err", err)
+	}
+}
@@ -0,0 +1,185 @@
+// Command loadgen generates HTTP load against a gcs-index instance and can
+// serve a synthetic GCS backend for it to list and read from.
+//
+// Typical usage:
+//
+//	loadgen -fake-backend :9000 &
+//	STORAGE_EMULATOR_HOST=localhost:9000 gcs-index /:loadgen: &
+//	loadgen -c 16 -d 30s http://localhost:8080/dir-000/ http://localhost:8080/dir-001/build-0.0.1.tar.gz
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var fakeAddr = flag.String("fake-backend", "", "serve a synthetic GCS JSON API on this address")
+var fakeBucket = flag.String("fake-bucket", "loadgen", "bucket name served by the fake backend")
+var fakeDirs = flag.Int("fake-dirs", 10, "number of directories in the fake bucket")
+var fakeObjects = flag.Int("fake-objects", 1000, "number of objects per directory in the fake bucket")
+var fakeSize = flag.Int("fake-size", 64*1024, "size in bytes of each fake object")
+var concurrency = flag.Int("c", 8, "number of concurrent workers")
+var duration = flag.Duration("d", 10*time.Second, "duration of the load test")
+var requests = flag.Int("n", 0, "stop after this many requests (0 means no limit)")
+var verbose = flag.Bool("v", false, "enable verbose logging")
+
+type result struct {
+	latency time.Duration
+	status  int
+	bytes   int64
+	err     error
+}
+
+func main() {
+	flag.Parse()
+
+	if *verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	targets := flag.Args()
+	if *fakeAddr == "" && len(targets) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-fake-backend addr] [url ...]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	if *fakeAddr != "" {
+		backend := newFakeBackend(*fakeBucket, *fakeDirs, *fakeObjects, *fakeSize)
+		slog.Info("serving fake backend", "addr", *fakeAddr, "bucket", *fakeBucket, "objects", len(backend.names))
+		go func() {
+			if err := http.ListenAndServe(*fakeAddr, backend); err != nil {
+				slog.Error("fake backend error", "err", err)
+				os.Exit(2)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(targets) == 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	results, elapsed := run(ctx, targets)
+	report(os.Stdout, results, elapsed)
+}
+
+func run(ctx context.Context, targets []string) ([]result, time.Duration) {
+	var mu sync.Mutex
+	var results []result
+	var issued atomic.Int64
+	var wg sync.WaitGroup
+
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}}
+	start := time.Now()
+
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var local []result
+			for i := w; ctx.Err() == nil; i += *concurrency {
+				if n := issued.Add(1); *requests > 0 && n > int64(*requests) {
+					break
+				}
+				res := fetch(ctx, client, targets[i%len(targets)])
+				if ctx.Err() != nil {
+					break
+				}
+				local = append(local, res)
+			}
+			mu.Lock()
+			results = append(results, local...)
+			mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+
+	return results, time.Since(start)
+}
+
+func fetch(ctx context.Context, client *http.Client, url string) (res result) {
+	start := time.Now()
+	defer func() { res.latency = time.Since(start) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		res.err = err
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		res.err = err
+		return
+	}
+	defer resp.Body.Close()
+
+	res.status = resp.StatusCode
+	res.bytes, res.err = io.Copy(io.Discard, resp.Body)
+	return
+}
+
+func report(w io.Writer, results []result, elapsed time.Duration) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "no requests completed")
+		return
+	}
+
+	var total time.Duration
+	var bytes int64
+	var errors int
+	var statuses = make(map[int]int)
+	var latencies = make([]time.Duration, 0, len(results))
+	for _, res := range results {
+		total += res.latency
+		bytes += res.bytes
+		latencies = append(latencies, res.latency)
+		if res.err != nil {
+			errors++
+		} else {
+			statuses[res.status]++
+		}
+	}
+	slices.Sort(latencies)
+
+	fmt.Fprintf(w, "requests:    %d (%d errors)\n", len(results), errors)
+	fmt.Fprintf(w, "throughput:  %.1f req/s, %.1f MiB/s\n",
+		float64(len(results))/elapsed.Seconds(),
+		float64(bytes)/elapsed.Seconds()/(1<<20))
+	fmt.Fprintf(w, "latency:     mean %v, p50 %v, p90 %v, p99 %v, max %v\n",
+		(total / time.Duration(len(results))).Round(time.Microsecond),
+		percentile(latencies, 50),
+		percentile(latencies, 90),
+		percentile(latencies, 99),
+		latencies[len(latencies)-1].Round(time.Microsecond))
+
+	var codes []int
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "status %d:  %d\n", code, statuses[code])
+	}
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100].Round(time.Microsecond)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	pathpkg "path"
+	"slices"
+	"strings"
+)
+
+// checkCollapse validates the patterns of collapsed directories.
+func checkCollapse(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := pathpkg.Match(pattern, ""); err != nil || pattern == "" || strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid collapse pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// collapsed tells whether an entry of a listing is in a directory collapsed
+// on the mount point, or is such a directory, given its name relative to the
+// listed directory.
+func (m *MountPoint) collapsed(name string) bool {
+	var segments = strings.Split(name, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		for _, pattern := range m.Collapse {
+			if ok, _ := pathpkg.Match(pattern, segment); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collapseListing hides the collapsed directories of the listing, and their
+// content in recursive searches, unless expanded.
+func collapseListing(listing *Listing, options ListOptions) {
+	if listing.mountPoint == nil || len(listing.mountPoint.Collapse) == 0 || options.Expand {
+		return
+	}
+	listing.Items = slices.DeleteFunc(listing.Items, func(item Item) bool {
+		if listing.mountPoint.collapsed(item.Name) {
+			listing.Collapsed++
+			return true
+		}
+		return false
+	})
+}
+
+// expandLink links to the listing with collapsed directories shown, keeping
+// the other query parameters.
+func expandLink(listing *Listing, query url.Values) {
+	if listing.Collapsed == 0 {
+		return
+	}
+	var values = maps.Clone(query)
+	values.Set("expand", "1")
+	values.Del("start")
+	listing.expand = "?" + values.Encode()
+}
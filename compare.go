@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// comparison lists the differences between the objects of two subdirectories,
+// by name relative to them.
+type comparison struct {
+	Path      string        `json:"path"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Added     []Item        `json:"added"`
+	Removed   []Item        `json:"removed"`
+	Changed   []changedItem `json:"changed"`
+	Unchanged []Item        `json:"unchanged"`
+}
+
+type changedItem struct {
+	Name string `json:"name"`
+	From Item   `json:"from"`
+	To   Item   `json:"to"`
+}
+
+// parseCompare splits the directories of ?compare=v1.2.0..v1.3.0, which must
+// be subdirectories of the listed one.
+func parseCompare(value string) (from, to string, err error) {
+	from, to, ok := strings.Cut(value, "..")
+	for _, dir := range []*string{&from, &to} {
+		*dir = strings.TrimSuffix(*dir, "/")
+		if *dir == "" || *dir == "." || *dir == ".." || strings.Contains(*dir, "/") {
+			ok = false
+		}
+		*dir += "/"
+	}
+	if !ok {
+		return "", "", fmt.Errorf("invalid comparison %q", value)
+	}
+	return from, to, nil
+}
+
+// handleCompare answers ?compare= on a directory, in JSON or as an HTML
+// listing of the differences.
+func handleCompare(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint) {
+	ctx, span := tracer.Start(r.Context(), "handleCompare")
+	defer span.End()
+
+	from, to, err := parseCompare(r.URL.Query().Get("compare"))
+	if err != nil {
+		slog.Warn("invalid comparison", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var format = negotiateFormat(r)
+	var isJSON = format.ContentType == jsonContentType || format.ContentType == vendorJsonContentType
+	if !isJSON && format.ContentType != htmlFormat.ContentType {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	if mountPoint == nil || hasNoIndexMarker(ctx, mountPoint, r.URL.Path+from) || hasNoIndexMarker(ctx, mountPoint, r.URL.Path+to) {
+		notFound(w, r, mountPoint)
+		return
+	}
+
+	result, err := compareDirectories(ctx, mountPoint, r.URL.Path, from, to)
+	if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to compare directories", "path", r.URL.Path, "from", from, "to", to, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	var common = len(result.Changed) + len(result.Unchanged)
+	if common+len(result.Removed) == 0 || common+len(result.Added) == 0 {
+		// Either directory is empty, or missing.
+		notFound(w, r, mountPoint)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Cache-Control", mountPoint.CacheControl)
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	var links = linksFor(r)
+	if isJSON {
+		for _, items := range []struct {
+			dir   string
+			items []Item
+		}{{to, result.Added}, {from, result.Removed}, {to, result.Unchanged}} {
+			for i := range items.items {
+				items.items[i].URL = links.Absolute(r.URL.Path + items.dir + items.items[i].Name)
+			}
+		}
+		for i := range result.Changed {
+			result.Changed[i].From.URL = links.Absolute(r.URL.Path + from + result.Changed[i].Name)
+			result.Changed[i].To.URL = links.Absolute(r.URL.Path + to + result.Changed[i].Name)
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	// The differences are listed as entries of the directory, in sections.
+	var listing = &Listing{
+		Path:       r.URL.Path,
+		IndexedAt:  time.Now(),
+		mountPoint: mountPoint,
+		links:      links,
+		messages:   messagesFor(r),
+		skin:       skinFor(r, mountPoint),
+		sections:   make(map[int]string),
+	}
+	var section = func(message, dir string, items []Item) {
+		if len(items) > 0 {
+			listing.sections[len(listing.Items)] = fmt.Sprintf(listing.messages[message], len(items))
+		}
+		for _, item := range items {
+			item.Name = dir + item.Name
+			listing.Items = append(listing.Items, item)
+		}
+	}
+	var changed []Item
+	for _, item := range result.Changed {
+		changed = append(changed, item.To)
+	}
+	section("added", to, result.Added)
+	section("removed", from, result.Removed)
+	section("changed", to, changed)
+	section("unchanged", to, result.Unchanged)
+	setSecurityHeaders(w.Header(), mountPoint, false)
+	var body bytes.Buffer
+	renderHTML(ctx, &body, listing)
+	body.WriteTo(w)
+}
+
+// compareDirectories walks two subdirectories of path. Objects are told apart
+// by their MD5, or by their size for composite objects which have none.
+func compareDirectories(ctx context.Context, mountPoint *MountPoint, path, from, to string) (comparison, error) {
+	var result = comparison{
+		Path:      path,
+		From:      from,
+		To:        to,
+		Added:     []Item{},
+		Removed:   []Item{},
+		Changed:   []changedItem{},
+		Unchanged: []Item{},
+	}
+	var previous []Item
+	var byName = make(map[string]Item)
+	_, _, err := walkStorage(ctx, mountPoint, path+from, ListOptions{Recursive: true}, 0, func(item Item) {
+		if !item.Dir && !strings.HasSuffix(item.Name, "/") {
+			previous = append(previous, item)
+			byName[item.Name] = item
+		}
+	})
+	if err != nil {
+		return result, err
+	}
+
+	_, _, err = walkStorage(ctx, mountPoint, path+to, ListOptions{Recursive: true}, 0, func(item Item) {
+		if item.Dir || strings.HasSuffix(item.Name, "/") {
+			return
+		}
+		old, ok := byName[item.Name]
+		switch {
+		case !ok:
+			result.Added = append(result.Added, item)
+		case old.MD5 != item.MD5 || old.Size != item.Size:
+			result.Changed = append(result.Changed, changedItem{Name: item.Name, From: old, To: item})
+		default:
+			result.Unchanged = append(result.Unchanged, item)
+		}
+		delete(byName, item.Name)
+	})
+	if err != nil {
+		return result, err
+	}
+	for _, item := range previous {
+		if _, ok := byName[item.Name]; ok {
+			result.Removed = append(result.Removed, item)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of the file given with -config.
+type Config struct {
+	Mounts []MountConfig `yaml:"mounts"`
+}
+
+// MountConfig describes a mount point in the config file. Options left out
+// fall back to the value of the corresponding command-line flag.
+type MountConfig struct {
+	Path             string    `yaml:"path"`
+	Bucket           string    `yaml:"bucket"`
+	Prefix           string    `yaml:"prefix"`
+	Readme           *bool     `yaml:"readme"`
+	SkipReadme       *bool     `yaml:"skip-readme"`
+	VersionSort      *bool     `yaml:"version-sort"`
+	VersionScheme    *string   `yaml:"version-scheme"`
+	NaturalSort      *bool     `yaml:"natural-sort"`
+	CacheControl     *string   `yaml:"cache-control"`
+	RedirectSigned   *bool     `yaml:"redirect-signed"`
+	BaseURL          *string   `yaml:"base-url"`
+	DefaultDocuments *[]string `yaml:"default-documents"`
+	DefaultCharset   *string   `yaml:"default-charset"`
+	Skin             *string   `yaml:"skin"`
+	Robots           *string   `yaml:"robots"`
+	Writable         bool      `yaml:"writable"`
+	WriteOnce        bool      `yaml:"write-once"`
+	Staging          string    `yaml:"staging"`
+	Collapse         []string  `yaml:"collapse"`
+	Stable           bool      `yaml:"stable"`
+	Listings         *bool     `yaml:"listings"`
+	Privacy          *string   `yaml:"privacy"`
+	PrimeURL         *string   `yaml:"prime-url"`
+	NotFoundPage     *string   `yaml:"not-found-page"`
+	Naming           []struct {
+		Pattern string `yaml:"pattern"`
+		Message string `yaml:"message"`
+	} `yaml:"naming"`
+	Scanner *struct {
+		URL     string        `yaml:"url"`
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"scanner"`
+	BasicAuth *struct {
+		Realm    string            `yaml:"realm"`
+		Users    map[string]string `yaml:"users"`
+		Htpasswd string            `yaml:"htpasswd"`
+	} `yaml:"basic-auth"`
+	OIDC *struct {
+		Issuer   string      `yaml:"issuer"`
+		JWKSURL  string      `yaml:"jwks-url"`
+		Audience string      `yaml:"audience"`
+		Rules    []ClaimRule `yaml:"rules"`
+	} `yaml:"oidc"`
+	CORS *struct {
+		Origins *[]string      `yaml:"origins"`
+		Methods *[]string      `yaml:"methods"`
+		Headers *[]string      `yaml:"headers"`
+		MaxAge  *time.Duration `yaml:"max-age"`
+	} `yaml:"cors"`
+	SecurityHeaders *struct {
+		ApplyTo *string           `yaml:"apply-to"`
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"security-headers"`
+	DeleteApproval *struct {
+		Approvers []string      `yaml:"approvers"`
+		TTL       time.Duration `yaml:"ttl"`
+	} `yaml:"delete-approval"`
+}
+
+func loadConfig(path string) ([]MountPoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var result []MountPoint
+	for i, mc := range config.Mounts {
+		mountPoint, err := newMountPoint(mc.Path, mc.Bucket, mc.Prefix)
+		if err == nil {
+			err = mc.apply(&mountPoint)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: mount #%d: %w", path, i+1, err)
+		}
+		result = append(result, mountPoint)
+	}
+	return result, nil
+}
+
+// apply sets the options of a mount point given in its config.
+func (mc MountConfig) apply(mountPoint *MountPoint) (err error) {
+	setIfNotNil(&mountPoint.Readme, mc.Readme)
+	setIfNotNil(&mountPoint.SkipReadme, mc.SkipReadme)
+	setIfNotNil(&mountPoint.VersionSort, mc.VersionSort)
+	setIfNotNil(&mountPoint.VersionScheme, mc.VersionScheme)
+	setIfNotNil(&mountPoint.NaturalSort, mc.NaturalSort)
+	if err := checkVersionScheme(mountPoint.VersionScheme); err != nil {
+		return err
+	}
+	setIfNotNil(&mountPoint.CacheControl, mc.CacheControl)
+	setIfNotNil(&mountPoint.RedirectSigned, mc.RedirectSigned)
+	setIfNotNil(&mountPoint.BaseURL, mc.BaseURL)
+	setIfNotNil(&mountPoint.DefaultDocuments, mc.DefaultDocuments)
+	setIfNotNil(&mountPoint.DefaultCharset, mc.DefaultCharset)
+	setIfNotNil(&mountPoint.Skin, mc.Skin)
+	if mc.Robots != nil {
+		if err := checkRobotsPolicy(*mc.Robots); err != nil {
+			return err
+		}
+		mountPoint.Robots = *mc.Robots
+	}
+	setIfNotNil(&mountPoint.PrimeURL, mc.PrimeURL)
+	if err := checkPrimeURL(mountPoint.PrimeURL); err != nil {
+		return err
+	}
+	setIfNotNil(&mountPoint.NotFoundPage, mc.NotFoundPage)
+	if err := checkNotFoundPage(mountPoint.NotFoundPage); err != nil {
+		return err
+	}
+	if skins[mountPoint.Skin] == nil {
+		return fmt.Errorf("unknown skin %q", mountPoint.Skin)
+	}
+	mountPoint.Writable = mc.Writable
+	mountPoint.WriteOnce = mc.WriteOnce
+	if err := checkStaging(mc.Staging); err != nil {
+		return err
+	}
+	mountPoint.Staging = mc.Staging
+	if err := checkCollapse(mc.Collapse); err != nil {
+		return err
+	}
+	mountPoint.Collapse = mc.Collapse
+	mountPoint.Stable = mc.Stable
+	setIfNotNil(&mountPoint.Privacy, mc.Privacy)
+	if mc.Listings != nil {
+		mountPoint.NoListings = !*mc.Listings
+	} else if mountPoint.Privacy == privacyUnlisted {
+		mountPoint.NoListings = true
+	}
+	for _, nc := range mc.Naming {
+		rule, err := newNamingRule(nc.Pattern, nc.Message)
+		if err != nil {
+			return err
+		}
+		mountPoint.NamingRules = append(mountPoint.NamingRules, rule)
+	}
+	if mc.Scanner != nil {
+		if mountPoint.Scanner, err = newHTTPScanner(mc.Scanner.URL, mc.Scanner.Timeout); err != nil {
+			return err
+		}
+	}
+	if mc.BasicAuth != nil {
+		if mountPoint.BasicAuth, err = newBasicAuth(mc.BasicAuth.Realm, mc.BasicAuth.Users, mc.BasicAuth.Htpasswd); err != nil {
+			return err
+		}
+	}
+	if mc.OIDC != nil {
+		if mountPoint.OIDCAuth, err = newOIDCAuth(mc.OIDC.Issuer, mc.OIDC.JWKSURL, mc.OIDC.Audience, mc.OIDC.Rules); err != nil {
+			return err
+		}
+	}
+	if mc.CORS != nil {
+		var cors = corsFlags()
+		setIfNotNil(&cors.Origins, mc.CORS.Origins)
+		setIfNotNil(&cors.Methods, mc.CORS.Methods)
+		setIfNotNil(&cors.Headers, mc.CORS.Headers)
+		setIfNotNil(&cors.MaxAge, mc.CORS.MaxAge)
+		if err := checkCORS(&cors); err != nil {
+			return err
+		}
+		mountPoint.CORS = nil
+		if len(cors.Origins) > 0 {
+			mountPoint.CORS = &cors
+		}
+	}
+	if mc.SecurityHeaders != nil {
+		setIfNotNil(&mountPoint.SecurityScope, mc.SecurityHeaders.ApplyTo)
+		if err := checkSecurityScope(mountPoint.SecurityScope); err != nil {
+			return err
+		}
+		mountPoint.SecurityHeaders = mergeSecurityHeaders(mountPoint.SecurityHeaders, mc.SecurityHeaders.Headers)
+	}
+	if mc.DeleteApproval != nil {
+		if mountPoint.BasicAuth == nil && mountPoint.OIDCAuth == nil {
+			return errors.New("delete approval requires authentication")
+		}
+		if len(mc.DeleteApproval.Approvers) == 0 {
+			return errors.New("delete approval requires approvers")
+		}
+		mountPoint.DeleteApproval = &DeleteApproval{Approvers: mc.DeleteApproval.Approvers, TTL: mc.DeleteApproval.TTL}
+		if mountPoint.DeleteApproval.TTL <= 0 {
+			mountPoint.DeleteApproval.TTL = 24 * time.Hour
+		}
+	}
+	if err := checkPrivacy(mountPoint); err != nil {
+		return err
+	}
+	if mountPoint.Writable && mountPoint.BasicAuth == nil && mountPoint.OIDCAuth == nil && *iapAudience == "" {
+		return errors.New("writable mount points require authentication")
+	}
+	if err := checkBaseURL(mountPoint.BaseURL); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseMountOptions reads the options of a mount point given on the command
+// line, as in path:bucket:prefix?version-sort&skin=cards. They are those of the
+// config file, with an empty value meaning true and repeated keys making lists.
+func parseMountOptions(options string) (MountConfig, error) {
+	var mc MountConfig
+	query, err := url.ParseQuery(options)
+	if err != nil {
+		return mc, err
+	}
+
+	var mapping = &yaml.Node{Kind: yaml.MappingNode}
+	var keys = make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		switch key {
+		case "path", "bucket", "prefix":
+			return mc, fmt.Errorf("option %q must be given as path:bucket:prefix", key)
+		}
+		var value = &yaml.Node{Kind: yaml.SequenceNode}
+		for _, v := range query[key] {
+			if v == "" {
+				v = "true"
+			}
+			value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: v})
+		}
+		if len(value.Content) == 1 && !isListOption(key) {
+			value = value.Content[0]
+		}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+
+	// Decoding through YAML rejects unknown options and mistyped values.
+	data, err := yaml.Marshal(mapping)
+	if err != nil {
+		return mc, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&mc); err != nil && !errors.Is(err, io.EOF) {
+		return mc, err
+	}
+	return mc, nil
+}
+
+// isListOption tells whether a mount option of the config file is a list.
+func isListOption(key string) bool {
+	var t = reflect.TypeOf(MountConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.Tag.Get("yaml") == key {
+			var kind = field.Type.Kind()
+			if kind == reflect.Pointer {
+				kind = field.Type.Elem().Kind()
+			}
+			return kind == reflect.Slice
+		}
+	}
+	return false
+}
+
+func setIfNotNil[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
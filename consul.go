@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var consulClient = &http.Client{Timeout: 10 * time.Second}
+
+// consulRegistration is a service definition of the Consul agent API.
+type consulRegistration struct {
+	ID    string
+	Name  string
+	Port  int
+	Check consulCheck
+}
+
+type consulCheck struct {
+	HTTP                           string `json:",omitempty"`
+	TCP                            string `json:",omitempty"`
+	Interval                       string
+	DeregisterCriticalServiceAfter string
+}
+
+func checkConsul() error {
+	if *consulAddr == "" {
+		return nil
+	}
+	u, err := url.Parse(*consulAddr)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Consul address %q is not http or https", *consulAddr)
+	}
+	if *socket != "" {
+		return errors.New("Consul registration needs -port, not -socket")
+	}
+	return nil
+}
+
+// consulServiceID identifies the instance among those of the service, which
+// may share a host on different ports.
+func consulServiceID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s-%d", *consulService, host, *port)
+}
+
+// registerConsul registers the instance with the local Consul agent, checked
+// through /ready on the metrics address so that draining instances leave the
+// service, or with TCP connections without one.
+func registerConsul(ctx context.Context) error {
+	var registration = consulRegistration{
+		ID:   consulServiceID(),
+		Name: *consulService,
+		Port: *port,
+		Check: consulCheck{
+			Interval: "10s",
+			// Instances that died without deregistering.
+			DeregisterCriticalServiceAfter: "10m",
+		},
+	}
+	if *metricsAddr != "" {
+		registration.Check.HTTP = "http://" + localAddr(*metricsAddr) + "/ready"
+	} else {
+		registration.Check.TCP = localAddr(fmt.Sprintf(":%d", *port))
+	}
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	return consulRequest(ctx, "/v1/agent/service/register", body)
+}
+
+func deregisterConsul(ctx context.Context) error {
+	return consulRequest(ctx, "/v1/agent/service/deregister/"+url.PathEscape(consulServiceID()), nil)
+}
+
+// consulRequest calls the agent API, with the token of CONSUL_HTTP_TOKEN like
+// the consul command does.
+func consulRequest(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(*consulAddr, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	res, err := consulClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s: %s", path, res.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// localAddr returns the address at which the local agent reaches a listener.
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
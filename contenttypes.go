@@ -0,0 +1,21 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+)
+
+// contentTypesLink links HTML listings to themselves with the column of
+// content types shown with ?types, or hidden again.
+func contentTypesLink(listing *Listing, query url.Values) {
+	if listing.mountPoint == nil {
+		return
+	}
+	var values = maps.Clone(query)
+	if listing.types {
+		values.Del("types")
+	} else {
+		values.Set("types", "1")
+	}
+	listing.typesLink = "?" + values.Encode()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS lets web apps of other origins call a mount point from browsers.
+type CORS struct {
+	Origins []string // Origins allowed, or "*" for any.
+	Methods []string // Those of the mount point if empty.
+	Headers []string // Request headers allowed.
+	MaxAge  time.Duration
+}
+
+// corsExposedHeaders are the response headers scripts may read, besides the
+// safelisted ones.
+const corsExposedHeaders = "Content-Disposition, Content-Range, ETag, Link, X-Goog-Generation, X-Index-Generation"
+
+// defaultCORS returns the CORS settings of the command line, nil unless
+// -cors-origins is set.
+func defaultCORS() *CORS {
+	var cors = corsFlags()
+	if len(cors.Origins) == 0 {
+		return nil
+	}
+	return &cors
+}
+
+func corsFlags() CORS {
+	return CORS{
+		Origins: splitList(*corsOrigins),
+		Methods: splitList(strings.ToUpper(*corsMethods)),
+		Headers: splitList(*corsHeaders),
+		MaxAge:  *corsMaxAge,
+	}
+}
+
+// checkCORS validates origins, which browsers send as scheme://host[:port].
+func checkCORS(cors *CORS) error {
+	if cors == nil {
+		return nil
+	}
+	for _, origin := range cors.Origins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("invalid CORS origin %q", origin)
+		}
+	}
+	return nil
+}
+
+// handleCORS sets the CORS headers of a cross-origin request, and answers it if
+// it is a preflight, in which case it returns false.
+func handleCORS(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint) bool {
+	var cors = defaultCORS()
+	if mountPoint != nil {
+		cors = mountPoint.CORS
+	}
+	var origin = r.Header.Get("Origin")
+	if cors == nil || origin == "" {
+		return true
+	}
+	var preflight = r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	var h = w.Header()
+	var anyOrigin = slices.Contains(cors.Origins, "*")
+	if !anyOrigin {
+		h.Add("Vary", "Origin")
+		if !slices.Contains(cors.Origins, strings.TrimSuffix(origin, "/")) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return false
+			}
+			return true
+		}
+	}
+	if anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		return true
+	}
+
+	var methods = cors.Methods
+	if len(methods) == 0 {
+		methods = allowedMethods(mountPoint)
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(cors.Headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(cors.Headers, ", "))
+	}
+	if cors.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return false
+}
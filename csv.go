@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// csvFormat renders listings as CSV, or TSV with a tab separator, for import
+// into spreadsheets.
+func csvFormat(comma rune) ListingFormat {
+	var format = ListingFormat{ContentType: "text/csv; charset=utf-8", Extension: "csv"}
+	if comma == '\t' {
+		format = ListingFormat{ContentType: "text/tab-separated-values; charset=utf-8", Extension: "tsv"}
+	}
+	format.Render = func(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+		var writer = csv.NewWriter(w)
+		writer.Comma = comma
+		writer.Write([]string{"name", "size", "content-type", "md5", "updated"})
+		for _, item := range listing.Items {
+			if item.Dir {
+				writer.Write([]string{spreadsheetSafe(item.Name), "", "", "", ""})
+				continue
+			}
+			writer.Write([]string{
+				spreadsheetSafe(item.Name),
+				fmt.Sprint(item.Size),
+				spreadsheetSafe(item.ContentType),
+				item.MD5,
+				item.Updated.UTC().Format(time.RFC3339),
+			})
+		}
+		writer.Flush()
+	}
+	return format
+}
+
+// spreadsheetSafe keeps spreadsheets from evaluating values as formulas.
+func spreadsheetSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// listingFilename names downloaded listings after their directory.
+func listingFilename(path, extension string) string {
+	var name = pathpkg.Base(path)
+	if name == "/" {
+		name = "index"
+	}
+	return name + "." + extension
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// DeleteApproval requires deletes on a mount point to be confirmed by one of
+// the approvers, other than the user who asked for it.
+type DeleteApproval struct {
+	Approvers []string
+	TTL       time.Duration
+}
+
+// pendingDelete is a delete waiting for approval. Pending deletes are only
+// kept in memory, a restart drops them.
+type pendingDelete struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	Generation int64     `json:"generation"`
+	User       string    `json:"requestedBy"`
+	Expires    time.Time `json:"expires"`
+
+	bucket string
+	object string
+}
+
+var pendingDeletesMutex sync.Mutex
+var pendingDeletes = make(map[string]*pendingDelete)
+
+// handleDelete deletes an object of a writable mount point, or records the
+// request for approval if the mount point requires it.
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleDelete")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil || strings.HasSuffix(r.URL.Path, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if mountPoint.WriteOnce {
+		slog.Warn("delete refused on write-once mount point", "path", r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	obj := client.Bucket(mountPoint.Bucket).Object(name)
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to get object attributes", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	if mountPoint.DeleteApproval == nil {
+		if err := obj.Generation(attrs.Generation).Delete(ctx); err != nil {
+			span.RecordError(err)
+			slog.Error("failed to delete object", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		audit(r, "delete", "bucket", attrs.Bucket, "object", attrs.Name, "generation", attrs.Generation)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	expirePendingDeletes()
+	var pending = &pendingDelete{
+		ID:         randomID(),
+		Path:       r.URL.Path,
+		Generation: attrs.Generation,
+		User:       requestUser(r),
+		Expires:    time.Now().Add(mountPoint.DeleteApproval.TTL).UTC(),
+		bucket:     attrs.Bucket,
+		object:     attrs.Name,
+	}
+	pendingDeletesMutex.Lock()
+	pendingDeletes[pending.ID] = pending
+	pendingDeletesMutex.Unlock()
+
+	audit(r, "delete-requested", "id", pending.ID, "bucket", attrs.Bucket, "object", attrs.Name, "generation", attrs.Generation, "expires", pending.Expires)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(pending)
+}
+
+// reviewDelete approves or rejects a pending delete of the object at the
+// request path. Only approvers can approve, and never their own requests;
+// requesters can withdraw them.
+func reviewDelete(w http.ResponseWriter, r *http.Request, approve bool) {
+	ctx, span := tracer.Start(r.Context(), "reviewDelete")
+	defer span.End()
+
+	var mountPoint = findMountPoint(ctx, r.URL.Path)
+	if mountPoint == nil || mountPoint.DeleteApproval == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var user = requestUser(r)
+	var id = r.URL.Query().Get("id")
+
+	expirePendingDeletes()
+	pendingDeletesMutex.Lock()
+	pending, ok := pendingDeletes[id]
+	if !ok || pending.Path != r.URL.Path {
+		pendingDeletesMutex.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var isApprover = slices.Contains(mountPoint.DeleteApproval.Approvers, user)
+	if user == "" || (approve && (!isApprover || user == pending.User)) || (!approve && !isApprover && user != pending.User) {
+		pendingDeletesMutex.Unlock()
+		slog.Warn("delete review refused", "id", id, "user", user, "requestedBy", pending.User)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	delete(pendingDeletes, id)
+	pendingDeletesMutex.Unlock()
+
+	if !approve {
+		audit(r, "delete-rejected", "id", id, "requestedBy", pending.User, "bucket", pending.bucket, "object", pending.object)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	obj := client.Bucket(pending.bucket).Object(pending.object).Generation(pending.Generation)
+	if err := obj.Delete(ctx); errors.Is(err, storage.ErrObjectNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to delete object", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	audit(r, "delete-approved", "id", id, "requestedBy", pending.User, "bucket", pending.bucket, "object", pending.object, "generation", pending.Generation)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expirePendingDeletes drops expired requests, logging them for the record.
+// It runs whenever deletes are requested or reviewed.
+func expirePendingDeletes() {
+	pendingDeletesMutex.Lock()
+	defer pendingDeletesMutex.Unlock()
+	for id, pending := range pendingDeletes {
+		if time.Now().After(pending.Expires) {
+			slog.Info("audit", "action", "delete-expired", "id", id, "requestedBy", pending.User, "bucket", pending.bucket, "object", pending.object)
+			delete(pendingDeletes, id)
+		}
+	}
+}
+
+func randomID() string {
+	var id [12]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
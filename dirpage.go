@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Objects customizing the HTML listing of their directory.
+const (
+	dirHeaderObject   = ".header.html"
+	dirFooterObject   = ".footer.html"
+	dirTemplateObject = ".gcsindex.tmpl" // Replaces page.html.
+)
+
+var dirPageObjects = []string{dirHeaderObject, dirFooterObject, dirTemplateObject}
+
+// maxDirPageObjectSize bounds the objects customizing a listing.
+const maxDirPageObjectSize = 256 << 10
+
+// dirPage customizes the HTML listing of a directory, zero if it doesn't.
+type dirPage struct {
+	header    template.HTML
+	footer    template.HTML
+	templates map[string]*template.Template // By skin, nil without a template.
+	size      int
+}
+
+var dirPages = newMemoryCache("dir-page", 16<<20, 3*maxDirPageObjectSize, func(page dirPage) int {
+	return page.size
+})
+
+// dirPageFor returns the customizations of a directory's listing, read from
+// its objects at most once a minute.
+func dirPageFor(ctx context.Context, mountPoint *MountPoint, path string) dirPage {
+	var bucket = client.Bucket(mountPoint.Bucket)
+	var prefix = mountPoint.ObjectName(path)
+	page, _, err := dirPages.Get(ctx, mountPoint.Bucket+"/"+prefix, func(_ dirPage, fetched time.Time) bool {
+		return time.Since(fetched) < time.Minute
+	}, func(ctx context.Context) (dirPage, error) {
+		return readDirPage(ctx, bucket, prefix)
+	})
+	if err != nil {
+		slog.Error("failed to read directory page", "bucket", mountPoint.Bucket, "prefix", prefix, "err", err)
+	}
+	return page
+}
+
+func readDirPage(ctx context.Context, bucket *storage.BucketHandle, prefix string) (dirPage, error) {
+	var page dirPage
+	var contents = make(map[string][]byte, len(dirPageObjects))
+	for _, name := range dirPageObjects {
+		content, err := readDirPageObject(ctx, bucket.Object(prefix+name))
+		if err != nil {
+			return dirPage{}, fmt.Errorf("%s: %w", name, err)
+		}
+		contents[name] = content
+		page.size += len(content)
+	}
+
+	// Bucket owners may write HTML to their directories anyway.
+	page.header = template.HTML(contents[dirHeaderObject])
+	page.footer = template.HTML(contents[dirFooterObject])
+	if content := contents[dirTemplateObject]; content != nil {
+		templates, err := pageTemplates(dirTemplateObject, content)
+		if err != nil {
+			// Broken templates are left out until fixed, not retried on every request.
+			slog.Warn("invalid directory template", "object", prefix+dirTemplateObject, "err", err)
+		}
+		page.templates = templates
+	}
+	return page, nil
+}
+
+// readDirPageObject returns the content of an object, nil if it doesn't exist.
+func readDirPageObject(ctx context.Context, obj *storage.ObjectHandle) ([]byte, error) {
+	reader, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, maxDirPageObjectSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxDirPageObjectSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxDirPageObjectSize)
+	}
+	return content, nil
+}
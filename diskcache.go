@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const diskCacheTempPrefix = "tmp-"
+
+// maxDiskCacheCandidates bounds the number of objects whose requests are
+// counted before they are admitted in the cache.
+const maxDiskCacheCandidates = 10000
+
+// diskCache keeps whole objects on local disk, keyed by object generation so
+// that entries never go stale. Entries are evicted least recently used first
+// once the cache grows over its maximum size.
+//
+// Objects are only admitted if they are small enough and have been requested
+// often enough, so that a single large download doesn't flush hot entries.
+type diskCache struct {
+	dir           string
+	maxSize       int64
+	maxObjectSize int64 // Zero for no limit.
+	minHits       int
+
+	mu         sync.Mutex
+	size       int64
+	entries    map[string]*diskCacheEntry // By file name.
+	candidates map[string]int             // Requests seen for objects not admitted yet.
+}
+
+type diskCacheEntry struct {
+	size int64
+	used time.Time
+}
+
+var objectCache *diskCache // Nil when the disk cache is disabled.
+
+func newDiskCache(dir string, maxSize, maxObjectSize int64, minHits int) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var c = &diskCache{
+		dir:           dir,
+		maxSize:       maxSize,
+		maxObjectSize: maxObjectSize,
+		minHits:       minHits,
+		entries:       make(map[string]*diskCacheEntry),
+		candidates:    make(map[string]int),
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), diskCacheTempPrefix) {
+			// Leftover from an interrupted download
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		if info, err := file.Info(); err == nil && info.Mode().IsRegular() {
+			c.entries[file.Name()] = &diskCacheEntry{info.Size(), info.ModTime()}
+			c.size += info.Size()
+		}
+	}
+	c.evict()
+
+	slog.Info("disk cache ready", "dir", dir, "entries", len(c.entries), "size", c.size)
+	return c, nil
+}
+
+func diskCacheKey(bucket, name string, generation int64) string {
+	sum := sha256.Sum256([]byte(bucket + "/" + name))
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), generation)
+}
+
+// Open returns the cached file for the key, or nil if it isn't cached.
+func (c *diskCache) Open(key string) *os.File {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		entry.used = time.Now()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(filepath.Join(c.dir, key))
+	if err != nil {
+		slog.Warn("failed to open cached object", "key", key, "err", err)
+		c.remove(key)
+		return nil
+	}
+	return file
+}
+
+// Create returns a writer for a new entry, which only becomes visible once
+// committed with the expected size. It returns nil if the object isn't
+// admitted in the cache (yet).
+func (c *diskCache) Create(key string, size int64) *diskCacheWriter {
+	if !c.admit(key, size) {
+		return nil
+	}
+
+	file, err := os.CreateTemp(c.dir, diskCacheTempPrefix)
+	if err != nil {
+		slog.Warn("failed to create cache file", "err", err)
+		return nil
+	}
+	return &diskCacheWriter{cache: c, key: key, file: file}
+}
+
+// admit counts a request for an uncached object and tells whether it should
+// be cached now.
+func (c *diskCache) admit(key string, size int64) bool {
+	if size > c.maxSize || (c.maxObjectSize > 0 && size > c.maxObjectSize) {
+		slog.Debug("object too large for the disk cache", "key", key, "size", size)
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.candidates) >= maxDiskCacheCandidates {
+		// Forgetting everything is crude, but keeps memory bounded.
+		clear(c.candidates)
+	}
+	c.candidates[key]++
+	if c.candidates[key] < c.minHits {
+		slog.Debug("object not popular enough for the disk cache", "key", key, "hits", c.candidates[key])
+		return false
+	}
+	delete(c.candidates, key)
+	return true
+}
+
+func (c *diskCache) insert(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.size
+	}
+	c.entries[key] = &diskCacheEntry{size, time.Now()}
+	c.size += size
+	c.evict()
+}
+
+func (c *diskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		c.size -= entry.size
+		delete(c.entries, key)
+	}
+	os.Remove(filepath.Join(c.dir, key))
+}
+
+// evict must be called with the lock held.
+func (c *diskCache) evict() {
+	if c.size <= c.maxSize {
+		return
+	}
+
+	var keys = make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return c.entries[a].used.Compare(c.entries[b].used)
+	})
+
+	for _, key := range keys {
+		if c.size <= c.maxSize {
+			break
+		}
+		c.size -= c.entries[key].size
+		delete(c.entries, key)
+		os.Remove(filepath.Join(c.dir, key))
+		slog.Debug("evicted cached object", "key", key)
+	}
+}
+
+type diskCacheWriter struct {
+	cache   *diskCache
+	key     string
+	file    *os.File
+	written int64
+	err     error // First write error, after which writes are dropped.
+}
+
+// Write never fails, so that a full disk doesn't fail the download it is
+// cached along with; the entry is discarded on Commit instead.
+func (w *diskCacheWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		var n int
+		n, w.err = w.file.Write(p)
+		w.written += int64(n)
+	}
+	return len(p), nil
+}
+
+// Commit makes the entry visible if it is complete, and discards it otherwise.
+func (w *diskCacheWriter) Commit(size int64) {
+	if err := errors.Join(w.err, w.file.Close()); err != nil || w.written != size {
+		slog.Warn("discarding incomplete cache file", "key", w.key, "written", w.written, "expected", size, "err", err)
+		os.Remove(w.file.Name())
+		return
+	}
+	if err := os.Rename(w.file.Name(), filepath.Join(w.cache.dir, w.key)); err != nil {
+		slog.Warn("failed to commit cache file", "key", w.key, "err", err)
+		os.Remove(w.file.Name())
+		return
+	}
+	w.cache.insert(w.key, size)
+}
+
+// Abort discards the entry.
+func (w *diskCacheWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set once the process is about to stop, by /drain or a signal.
+var draining atomic.Bool
+
+// handleReady answers readiness probes, failing them once draining.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// handleDrain fails readiness probes from now on, and answers after
+// -drain-delay so that a preStop hook calling it holds off the shutdown until
+// load balancers stopped sending requests.
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !draining.Swap(true) {
+		slog.Warn("draining", "delay", *drainDelay)
+	}
+	select {
+	case <-time.After(*drainDelay):
+	case <-r.Context().Done():
+		return
+	}
+	w.Write([]byte("drained\n"))
+}
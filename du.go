@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"strings"
+)
+
+// addDiskUsage sets the size of the subdirectories of a listing to the total
+// size of the objects they hold, along with their number. The whole tree under
+// the directory is walked at once, which takes a while on large buckets; the
+// listing cache keeps the result.
+func addDiskUsage(ctx context.Context, listing *Listing) error {
+	type usage struct{ size, objects int64 }
+	var usages = make(map[string]*usage)
+	_, _, err := walkStorage(ctx, listing.mountPoint, listing.Path, ListOptions{Recursive: true}, 0, func(item Item) {
+		dir, _, nested := strings.Cut(item.Name, "/")
+		if !nested {
+			return
+		}
+		var u = usages[dir+"/"]
+		if u == nil {
+			u = new(usage)
+			usages[dir+"/"] = u
+		}
+		u.size += item.Size
+		u.objects++
+	})
+	if err != nil {
+		return err
+	}
+
+	listing.DiskUsage = true
+	for i, item := range listing.Items {
+		if u := usages[item.Name]; item.Dir && u != nil {
+			listing.Items[i].Size = u.size
+			listing.Items[i].Objects = u.objects
+		}
+	}
+	return nil
+}
+
+// diskUsageLink links HTML listings to their disk usage.
+func diskUsageLink(listing *Listing, query url.Values) {
+	if listing.DiskUsage || listing.mountPoint == nil || listing.Recursive || listing.AsOf != nil {
+		return
+	}
+	var values = maps.Clone(query)
+	values.Set("du", "1")
+	values.Del("start")
+	values.Del("prev")
+	listing.diskUsage = "?" + values.Encode()
+}
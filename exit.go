@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Exit codes are part of the interface with supervisors, never renumber them.
+const (
+	exitUsage       = 1 // Invalid command line.
+	exitConfig      = 2 // Invalid mount points or config file.
+	exitListen      = 3 // Failed to listen on the socket or port.
+	exitCredentials = 4 // Failed to create the storage client, usually missing credentials.
+	exitServe       = 5 // Server failed while running.
+	exitShutdown    = 6 // Graceful shutdown did not complete in time.
+	exitSelftest    = 7 // A check of the selftest command failed.
+	exitRegister    = 8 // Failed to register with service discovery.
+)
+
+type exitCode struct {
+	name        string
+	retryable   bool
+	description string
+}
+
+var exitCodes = map[int]exitCode{
+	exitUsage:       {"usage", false, "invalid command line"},
+	exitConfig:      {"config", false, "invalid mount points or config file"},
+	exitListen:      {"listen", true, "failed to listen on the socket or port"},
+	exitCredentials: {"credentials", true, "failed to create the storage client"},
+	exitServe:       {"serve", true, "server failed while running"},
+	exitShutdown:    {"shutdown", true, "graceful shutdown did not complete in time"},
+	exitSelftest:    {"selftest", false, "a check of the selftest command failed"},
+	exitRegister:    {"register", true, "failed to register with service discovery"},
+}
+
+// fatal is the single exit path for startup and runtime failures. With
+// -json-errors, a JSON object describing the failure is written to stderr so
+// that supervisors can tell retryable failures from fatal ones.
+func fatal(code int, msg string, err error) {
+	var info = exitCodes[code]
+	if *jsonErrors {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Code      int    `json:"code"`
+			Name      string `json:"name"`
+			Retryable bool   `json:"retryable"`
+			Message   string `json:"message"`
+			Error     string `json:"error,omitempty"`
+		}{code, info.name, info.retryable, msg, errorString(err)})
+	} else {
+		slog.Error(msg, "err", err, "exitCode", code, "retryable", info.retryable)
+	}
+	os.Exit(code)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// hiddenFlags are meant for testing and left out of the usage.
+var hiddenFlags = map[string]bool{"chaos": true}
+
+func printVisibleDefaults() {
+	var visible = flag.NewFlagSet(flag.CommandLine.Name(), flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
+
+func usage() {
+	var output = flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage: %s [flags] path:bucket:prefix[?options] [path:bucket:prefix[?options] ...]\n       %s [flags] selftest path [path:bucket:prefix[?options] ...]\n\nFlags:\n", os.Args[0], os.Args[0])
+	printVisibleDefaults()
+	fmt.Fprintf(output, "\nExit codes:\n")
+	for code := exitUsage; code <= exitRegister; code++ {
+		var info = exitCodes[code]
+		var kind = "fatal"
+		if info.retryable {
+			kind = "retryable"
+		}
+		fmt.Fprintf(output, "  %d  %-12s %-10s %s\n", code, info.name, kind, info.description)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// itemFields are the JSON fields of entries that ?fields can select.
+var itemFields = []string{"name", "dir", "size", "updated", "md5", "contentType", "url", "objects", "status", "storageClass", "customTime"}
+
+// parseFields reads a comma-separated list of entry fields.
+func parseFields(value string) (map[string]bool, error) {
+	var fields = map[string]bool{"name": true}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(itemFields, field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields[field] = true
+	}
+	return fields, nil
+}
+
+// selectFields clears the fields of an entry left out of the selection, which
+// are then omitted in JSON. The name is always kept.
+func (item *Item) selectFields(fields map[string]bool) {
+	if fields == nil {
+		return
+	}
+	if !fields["dir"] {
+		item.Dir = false
+	}
+	if !fields["size"] {
+		item.Size = 0
+	}
+	if !fields["updated"] {
+		item.Updated = nil
+	}
+	if !fields["md5"] {
+		item.MD5 = ""
+	}
+	if !fields["contentType"] {
+		item.ContentType = ""
+	}
+	if !fields["url"] {
+		item.URL = ""
+	}
+	if !fields["objects"] {
+		item.Objects = 0
+	}
+	if !fields["status"] {
+		item.Status = ""
+	}
+	if !fields["storageClass"] {
+		item.StorageClass = ""
+	}
+	if !fields["customTime"] {
+		item.CustomTime = nil
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+	"github.com/dustin/go-humanize"
+	"google.golang.org/api/iterator"
+)
+
+// gcUsage sums up the generations of objects under a prefix.
+type gcUsage struct {
+	Prefix            string `json:"prefix"`
+	NoncurrentObjects int64  `json:"noncurrentObjects"`
+	NoncurrentBytes   int64  `json:"noncurrentBytes"`
+	LiveObjects       int64  `json:"liveObjects"`
+	LiveBytes         int64  `json:"liveBytes"`
+}
+
+func (u *gcUsage) add(attrs *storage.ObjectAttrs) {
+	if attrs.Deleted.IsZero() {
+		u.LiveObjects++
+		u.LiveBytes += attrs.Size
+	} else {
+		u.NoncurrentObjects++
+		u.NoncurrentBytes += attrs.Size
+	}
+}
+
+// gcReport tells where noncurrent generations take up storage in a mount
+// point, by prefix.
+type gcReport struct {
+	Mount    string    `json:"mount"`
+	Bucket   string    `json:"bucket"`
+	Prefix   string    `json:"prefix"`
+	Total    gcUsage   `json:"total"`
+	Prefixes []gcUsage `json:"prefixes"`
+}
+
+// handleGCReport serves /gc-report on the metrics address: for each mount
+// point, or the one of ?mount=, the noncurrent generations by prefix, up to
+// ?depth= directories deep (1 by default), the largest first. Every generation
+// of every object is listed, which takes a while on large buckets.
+func handleGCReport(w http.ResponseWriter, r *http.Request) {
+	var depth = 1
+	if value := r.URL.Query().Get("depth"); value != "" {
+		var err error
+		if depth, err = strconv.Atoi(value); err != nil || depth < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var reports []gcReport
+	for _, mountPoint := range getMountPoints(r.Context()) {
+		if mount := r.URL.Query().Get("mount"); mount != "" && mount != mountPoint.Path {
+			continue
+		}
+		report, err := buildGCReport(r.Context(), &mountPoint, depth)
+		if err != nil {
+			slog.Error("failed to build gc report", "mount", mountPoint.Path, "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		reports = append(reports, report)
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", jsonContentType)
+		json.NewEncoder(w).Encode(reports)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var table = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, report := range reports {
+		fmt.Fprintf(table, "%s (gs://%s/%s)\n", report.Mount, report.Bucket, report.Prefix)
+		fmt.Fprintf(table, "noncurrent\tbytes\tlive\tbytes\t\tprefix\n")
+		for _, usage := range append(report.Prefixes, report.Total) {
+			fmt.Fprintf(table, "%d\t%s\t%d\t%s\t\t%s\n",
+				usage.NoncurrentObjects, humanize.IBytes(uint64(usage.NoncurrentBytes)),
+				usage.LiveObjects, humanize.IBytes(uint64(usage.LiveBytes)),
+				usage.Prefix)
+		}
+		fmt.Fprintln(table)
+	}
+	table.Flush()
+}
+
+func buildGCReport(ctx context.Context, mountPoint *MountPoint, depth int) (gcReport, error) {
+	var report = gcReport{
+		Mount:  mountPoint.Path,
+		Bucket: mountPoint.Bucket,
+		Prefix: mountPoint.Prefix,
+		Total:  gcUsage{Prefix: "total"},
+	}
+	var usages = make(map[string]*gcUsage)
+
+	objects := client.Bucket(mountPoint.Bucket).Objects(ctx, &storage.Query{Prefix: mountPoint.Prefix, Versions: true})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return report, err
+		}
+
+		var segments = strings.Split(strings.TrimPrefix(attrs.Name, mountPoint.Prefix), "/")
+		var prefix = strings.Join(segments[:min(depth, len(segments)-1)], "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		var usage = usages[prefix]
+		if usage == nil {
+			usage = &gcUsage{Prefix: mountPoint.Path + prefix}
+			usages[prefix] = usage
+		}
+		usage.add(attrs)
+		report.Total.add(attrs)
+	}
+
+	for _, usage := range usages {
+		report.Prefixes = append(report.Prefixes, *usage)
+	}
+	slices.SortFunc(report.Prefixes, func(a, b gcUsage) int {
+		return cmp.Or(cmp.Compare(b.NoncurrentBytes, a.NoncurrentBytes), strings.Compare(a.Prefix, b.Prefix))
+	})
+	return report, nil
+}
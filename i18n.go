@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"golang.org/x/text/language"
+)
+
+// messages holds the UI strings of HTML listings in one language.
+type messages map[string]string
+
+var catalog = map[string]messages{
+	"en": {
+		"parent":         "Parent directory",
+		"download":       "Download",
+		"search":         "Search",
+		"subdirectories": "Subdirectories",
+		"sort-by":        "Sort by",
+		"name":           "name",
+		"size":           "size",
+		"time":           "modified",
+		"first":          "First",
+		"previous":       "Previous",
+		"next":           "Next",
+		"per-page":       "%d entries per page",
+		"indexed-at":     "Index as of",
+		"bytes":          "bytes",
+		"collapsed":      "%d collapsed entries.",
+		"show-all":       "Show all",
+		"objects":        "%d objects",
+		"disk-usage":     "Disk usage",
+		"content-type":   "type",
+		"show-types":     "Show content types",
+		"hide-types":     "Hide content types",
+		"storage-class":  "storage class",
+		"custom-time":    "custom time",
+		"show-lifecycle": "Show storage classes",
+		"hide-lifecycle": "Hide storage classes",
+		"added":          "Added (%d)",
+		"removed":        "Removed (%d)",
+		"changed":        "Changed (%d)",
+		"unchanged":      "Unchanged (%d)",
+		"by-version":     "By version",
+		"other-files":    "Other files",
+		"filter":         "Filter this page",
+		"theme":          "Toggle dark mode",
+	},
+	"fr": {
+		"parent":         "Dossier parent",
+		"download":       "Télécharger",
+		"search":         "Rechercher",
+		"subdirectories": "Sous-dossiers",
+		"sort-by":        "Trier par",
+		"name":           "nom",
+		"size":           "taille",
+		"time":           "modification",
+		"first":          "Première",
+		"previous":       "Précédente",
+		"next":           "Suivante",
+		"per-page":       "%d entrées par page",
+		"indexed-at":     "Index du",
+		"bytes":          "octets",
+		"collapsed":      "%d entrées masquées.",
+		"show-all":       "Tout afficher",
+		"objects":        "%d objets",
+		"disk-usage":     "Espace disque",
+		"content-type":   "type",
+		"show-types":     "Afficher les types de contenu",
+		"hide-types":     "Masquer les types de contenu",
+		"storage-class":  "classe de stockage",
+		"custom-time":    "heure personnalisée",
+		"show-lifecycle": "Afficher les classes de stockage",
+		"hide-lifecycle": "Masquer les classes de stockage",
+		"added":          "Ajoutés (%d)",
+		"removed":        "Supprimés (%d)",
+		"changed":        "Modifiés (%d)",
+		"unchanged":      "Inchangés (%d)",
+		"by-version":     "Par version",
+		"other-files":    "Autres fichiers",
+		"filter":         "Filtrer cette page",
+		"theme":          "Basculer le mode sombre",
+	},
+	"de": {
+		"parent":         "Übergeordnetes Verzeichnis",
+		"download":       "Herunterladen",
+		"search":         "Suchen",
+		"subdirectories": "Unterverzeichnisse",
+		"sort-by":        "Sortieren nach",
+		"name":           "Name",
+		"size":           "Größe",
+		"time":           "Geändert",
+		"first":          "Erste",
+		"previous":       "Vorherige",
+		"next":           "Nächste",
+		"per-page":       "%d Einträge pro Seite",
+		"indexed-at":     "Index vom",
+		"bytes":          "Bytes",
+		"collapsed":      "%d ausgeblendete Einträge.",
+		"show-all":       "Alle anzeigen",
+		"objects":        "%d Objekte",
+		"disk-usage":     "Speicherbelegung",
+		"content-type":   "Typ",
+		"show-types":     "Inhaltstypen anzeigen",
+		"hide-types":     "Inhaltstypen ausblenden",
+		"storage-class":  "Speicherklasse",
+		"custom-time":    "benutzerdefinierte Zeit",
+		"show-lifecycle": "Speicherklassen anzeigen",
+		"hide-lifecycle": "Speicherklassen ausblenden",
+		"added":          "Hinzugefügt (%d)",
+		"removed":        "Entfernt (%d)",
+		"changed":        "Geändert (%d)",
+		"unchanged":      "Unverändert (%d)",
+		"by-version":     "Nach Version",
+		"other-files":    "Andere Dateien",
+		"filter":         "Diese Seite filtern",
+		"theme":          "Dunkelmodus umschalten",
+	},
+}
+
+// locales lists the languages of the catalog, the default one first as the
+// matcher falls back to it.
+var locales []string
+var localeMatcher language.Matcher
+
+func setupLocales(defaultLocale string) bool {
+	if _, ok := catalog[defaultLocale]; !ok {
+		return false
+	}
+	locales = []string{defaultLocale}
+	for locale := range catalog {
+		if locale != defaultLocale {
+			locales = append(locales, locale)
+		}
+	}
+	slices.Sort(locales[1:])
+
+	var tags []language.Tag
+	for _, locale := range locales {
+		tags = append(tags, language.MustParse(locale))
+	}
+	localeMatcher = language.NewMatcher(tags)
+	return true
+}
+
+// messagesFor picks the messages for the Accept-Language header of the request.
+func messagesFor(r *http.Request) messages {
+	accepted, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, index, _ := localeMatcher.Match(accepted...)
+	return catalog[locales[index]]
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/idtoken"
+)
+
+const iapIssuer = "https://cloud.google.com/iap"
+
+// checkIAP validates the assertion added by Identity-Aware Proxy (signature,
+// audience and issuer) and returns the verified email.
+func checkIAP(r *http.Request) (string, error) {
+	assertion := r.Header.Get("X-Goog-IAP-JWT-Assertion")
+	if assertion == "" {
+		return "", errors.New("missing assertion")
+	}
+
+	payload, err := idtoken.Validate(r.Context(), assertion, *iapAudience)
+	if err != nil {
+		return "", err
+	}
+	if payload.Issuer != iapIssuer {
+		return "", fmt.Errorf("unexpected issuer %q", payload.Issuer)
+	}
+
+	email, _ := payload.Claims["email"].(string)
+	if email == "" {
+		return "", errors.New("missing email claim")
+	}
+	return email, nil
+}
+
+// iapAllowed tells whether the email matches -iap-allow, which lists emails
+// and @domains. Any verified email is allowed if the list is empty.
+func iapAllowed(email string) bool {
+	allowed := splitList(*iapAllow)
+	if len(allowed) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(allowed, func(entry string) bool {
+		if strings.HasPrefix(entry, "@") {
+			return strings.EqualFold(entry, emailDomain(email))
+		}
+		return strings.EqualFold(entry, email)
+	})
+}
+
+func emailDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return email[i:]
+	}
+	return ""
+}
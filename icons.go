@@ -0,0 +1,49 @@
+package main
+
+import (
+	"mime"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Kinds of entries, which HTML listings show as icons.
+const (
+	kindFolder  = "folder"
+	kindArchive = "archive"
+	kindImage   = "image"
+	kindText    = "text"
+	kindBinary  = "binary"
+)
+
+var archiveExtensions = []string{".7z", ".apk", ".bz2", ".deb", ".dmg", ".gz", ".jar", ".rar", ".rpm", ".tar", ".tgz", ".whl", ".xz", ".zip", ".zst"}
+
+var archiveTypes = []string{"application/gzip", "application/java-archive", "application/vnd.rar", "application/x-7z-compressed", "application/x-bzip2", "application/x-tar", "application/x-xz", "application/zip", "application/zstd"}
+
+var textExtensions = []string{".asc", ".conf", ".csv", ".ini", ".log", ".md", ".sha256", ".sha512", ".toml", ".txt", ".yaml", ".yml"}
+
+var textTypes = []string{"application/javascript", "application/json", "application/toml", "application/xml", "application/yaml"}
+
+// itemKind tells what an entry holds from its content type, or from the
+// extension of its name for objects uploaded without a meaningful one.
+func itemKind(item Item) string {
+	if item.Dir {
+		return kindFolder
+	}
+	var ext = strings.ToLower(path.Ext(item.Name))
+	var contentType = item.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mime.TypeByExtension(ext)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case slices.Contains(archiveExtensions, ext) || slices.Contains(archiveTypes, mediaType):
+		return kindArchive
+	case strings.HasPrefix(mediaType, "image/"):
+		return kindImage
+	case strings.HasPrefix(mediaType, "text/") || slices.Contains(textTypes, mediaType) ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") || slices.Contains(textExtensions, ext):
+		return kindText
+	}
+	return kindBinary
+}
@@ -1,132 +1,688 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"cmp"
 	"context"
-	_ "embed"
 	"fmt"
+	"hash/fnv"
+	"html/template"
 	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
+	"net/url"
+	pathpkg "path"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/dustin/go-humanize"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/iterator"
 )
 
-type Link struct {
-	Target string
-	Extra  string
+// Item is a single entry of a directory listing: either an object or a
+// directory (a common prefix in the bucket, or a nested mount point).
+type Item struct {
+	Name         string     `json:"name"`
+	Dir          bool       `json:"dir,omitempty"`
+	Size         int64      `json:"size,omitempty"`
+	Updated      *time.Time `json:"updated,omitempty"`
+	MD5          string     `json:"md5,omitempty"`
+	ContentType  string     `json:"contentType,omitempty"`
+	URL          string     `json:"url,omitempty"`
+	Objects      int64      `json:"objects,omitempty"` // Objects under a directory, with ?du.
+	Status       string     `json:"status,omitempty"`  // Comma-separated, see statusMetadataKey.
+	StorageClass string     `json:"storageClass,omitempty"`
+	CustomTime   *time.Time `json:"customTime,omitempty"` // Lifecycle rules may key off it.
+
+	generation int64 // Zero for directories.
+}
+
+// Listing is the content of a directory index, independent of its format.
+type Listing struct {
+	Path      string     `json:"path"`
+	Items     []Item     `json:"items"`
+	Truncated bool       `json:"truncated"`
+	Query     string     `json:"query,omitempty"`
+	Recursive bool       `json:"recursive,omitempty"`
+	Sort      string     `json:"sort,omitempty"`
+	Order     string     `json:"order,omitempty"`
+	Start     string     `json:"start,omitempty"`  // Cursor of this page, empty on the first one.
+	Cursor    string     `json:"cursor,omitempty"` // Cursor of the next page.
+	Next      string     `json:"next,omitempty"`
+	Prev      string     `json:"prev,omitempty"`
+	Collapsed int        `json:"collapsed,omitempty"` // Number of collapsed entries left out.
+	IndexedAt time.Time  `json:"indexedAt"`           // When the listing was fetched from GCS.
+	DiskUsage bool       `json:"diskUsage,omitempty"` // Directories have the total size of their objects.
+	AsOf      *time.Time `json:"asOf,omitempty"`      // Entries are the generations live at that time.
+
+	mountPoint *MountPoint // Might be nil for directories holding only mount points.
+	readme     *storage.ObjectAttrs
+	links      *Links
+	first      string
+	sortLinks  [][2]string // Column and link to sort by it.
+	messages   messages
+	skin       string
+	view       string // Alternative presentation of HTML listings, see versionsView.
+	expand     string
+	fields     map[string]bool
+	diskUsage  string         // Link to the disk usage of the directory.
+	versions   string         // Link to the versions view of the directory.
+	sections   map[int]string // Headings of the entries starting sections, by index.
+	types      bool           // Show the content types of objects in HTML.
+	typesLink  string         // Link showing or hiding them.
+	classes    bool           // Show the storage classes and custom times of objects in HTML.
+	classLink  string         // Link showing or hiding them.
+	version    uint64         // Changes with the entries, see listingVersion.
+}
+
+// ListOptions selects the entries of a directory listing, from the query
+// parameters of the request.
+type ListOptions struct {
+	Start     string
+	Query     string // Case-insensitive search in names.
+	Recursive bool   // Search objects of subdirectories as well.
+	Match     string // Glob on the base name of files.
+	Regex     *regexp.Regexp
+	Expand    bool            // Show collapsed directories.
+	Sorted    bool            // List the whole directory for sorted paging.
+	DiskUsage bool            // Sum up the objects of subdirectories.
+	Natural   bool            // Compare numbers in names as numbers.
+	Stable    bool            // Hide pre-release versions.
+	NoYanked  bool            // Hide entries with the yanked status.
+	AsOf      time.Time       // List the generations live at that time, if not zero.
+	After     *Item           // Cursor of sorted paging, not part of the cache key.
+	Fields    map[string]bool // JSON fields of entries, all if nil; not part of the cache key.
+}
+
+func listOptionsFor(query url.Values, mountPoint *MountPoint) (ListOptions, error) {
+	var options = ListOptions{
+		Start:     query.Get("start"),
+		Query:     query.Get("q"),
+		Recursive: query.Get("q") != "" && query.Get("recursive") != "",
+		Match:     query.Get("match"),
+		Expand:    query.Get("expand") != "",
+		Natural:   naturalSortFor(query, mountPoint),
+		Sorted:    sortedPaging(query, versionSchemeFor(mountPoint) != "" || naturalSortFor(query, mountPoint)),
+		DiskUsage: query.Has("du"),
+		Stable:    mountPoint != nil && mountPoint.Stable,
+		NoYanked:  true,
+	}
+	if value := query.Get("exclude-yanked"); value != "" {
+		var err error
+		if options.NoYanked, err = strconv.ParseBool(value); err != nil {
+			return options, fmt.Errorf("exclude-yanked: %w", err)
+		}
+	}
+	if value := query.Get("asOf"); value != "" {
+		var err error
+		if options.AsOf, err = parseAsOf(value); err != nil {
+			return options, fmt.Errorf("asOf: %w", err)
+		}
+	}
+	if value := query.Get("stable"); value != "" {
+		var err error
+		if options.Stable, err = strconv.ParseBool(value); err != nil {
+			return options, fmt.Errorf("stable: %w", err)
+		}
+	}
+	if options.Sorted && options.Start != "" {
+		var err error
+		if options.After, err = decodeSortCursor(options.Start); err != nil {
+			return options, fmt.Errorf("start: %w", err)
+		}
+		options.Start = ""
+	}
+	if _, err := pathpkg.Match(options.Match, ""); err != nil {
+		return options, fmt.Errorf("match: %w", err)
+	}
+	if value := query.Get("regex"); value != "" {
+		var err error
+		if options.Regex, err = regexp.Compile(value); err != nil {
+			return options, fmt.Errorf("regex: %w", err)
+		}
+	}
+	if value := query.Get("fields"); value != "" {
+		var err error
+		if options.Fields, err = parseFields(value); err != nil {
+			return options, fmt.Errorf("fields: %w", err)
+		}
+	}
+	return options, nil
+}
+
+// key identifies the listing in the listing cache, along with its path.
+func (o ListOptions) key() string {
+	var regex string
+	if o.Regex != nil {
+		regex = o.Regex.String()
+	}
+	return fmt.Sprintf("start=%q&q=%q&recursive=%t&match=%q&regex=%q&expand=%t&sorted=%t&du=%t&stable=%t&asOf=%d&natural=%t&excludeYanked=%t", o.Start, o.Query, o.Recursive, o.Match, regex, o.Expand, o.Sorted, o.DiskUsage, o.Stable, o.AsOf.UnixNano(), o.Natural, o.NoYanked)
+}
+
+// filter tells whether a file passes the match and regex filters, which don't
+// apply to directories.
+func (o ListOptions) filter(name string) bool {
+	if o.Match != "" {
+		if ok, _ := pathpkg.Match(o.Match, pathpkg.Base(name)); !ok {
+			return false
+		}
+	}
+	return o.Regex == nil || o.Regex.MatchString(name)
+}
+
+func (o ListOptions) match(name string) bool {
+	return o.Query == "" || strings.Contains(strings.ToLower(name), strings.ToLower(o.Query))
+}
+
+// ListingFormat renders a listing into a response body.
+type ListingFormat struct {
+	ContentType string
+	Render      func(ctx context.Context, w *bytes.Buffer, listing *Listing)
+	Extension   string // Listings with an extension are downloaded as files.
 }
 
-//go:embed page.html
-var pageHtml []byte
+var htmlFormat = ListingFormat{ContentType: "text/html", Render: renderHTML}
+
+const maxCachedListingItems = 100000
+
+// listingCache holds listings by path and start offset when -listing-cache-ttl
+// is set, and keeps serving them while they are refreshed in the background.
+var listingCache = newMemoryCache("listing", maxCachedListingItems, maxCachedListingItems, func(listing *Listing) int {
+	return len(listing.Items) + 1
+})
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
+	ctx, span := tracer.Start(r.Context(), "handleIndex")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	var format = negotiateFormat(r)
+	var cacheControl = defaultCacheControl
+	var mountPoint = findMountPoint(ctx, r.URL.Path)
+	if mountPoint != nil && (mountPoint.NoListings || hasNoIndexMarker(ctx, mountPoint, r.URL.Path)) {
+		// Like a static website: the default document or nothing, whatever the
+		// format asked for.
+		if obj, attrs := findDefaultDocument(ctx, mountPoint, r.URL.Path); obj != nil {
+			serveObject(w, r, mountPoint, obj, attrs)
+		} else {
+			notFound(w, r, mountPoint)
+		}
+		return
+	}
+	if r.URL.Query().Has("archive") {
+		handleArchive(w, r, mountPoint)
+		return
+	}
+	if r.URL.Query().Has("compare") {
+		handleCompare(w, r, mountPoint)
+		return
+	}
+	if mountPoint != nil {
+		// Browsers get the default document of the directory, if any.
+		if format.ContentType == htmlFormat.ContentType {
+			if obj, attrs := findDefaultDocument(ctx, mountPoint, r.URL.Path); obj != nil {
+				serveObject(w, r, mountPoint, obj, attrs)
+				return
+			}
+		}
+		cacheControl = mountPoint.CacheControl
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	if format.ContentType == htmlFormat.ContentType {
+		setSecurityHeaders(w.Header(), mountPoint, false)
+	}
+	if format.Extension != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": listingFilename(r.URL.Path, format.Extension)}))
+	}
 	w.Header().Set("Last-Modified", time.Now().Truncate(time.Minute).Format(http.TimeFormat)) // Listing shows relative timestamps.
-	w.Header().Set("Cache-Control", defaultCacheControl)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	switch format.ContentType {
+	case htmlFormat.ContentType:
+		w.Header().Add("Link", alternateLink(r.URL.Query(), "json", jsonContentType))
+	case jsonContentType, vendorJsonContentType:
+		w.Header().Add("Link", alternateLink(r.URL.Query(), "html", htmlFormat.ContentType))
+	}
+
+	options, err := listOptionsFor(r.URL.Query(), mountPoint)
+	if err != nil {
+		slog.Warn("invalid listing options", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	if r.Method == http.MethodHead {
 		// Directory index always returns 200 OK.
 		return
 	}
 
-	var links []Link
+	if r.URL.Query().Get("format") == "ndjson" {
+		streamNDJSON(w, r, options)
+		return
+	}
+
+	// Listing runs in a worker so that the handler can give up as soon as the
+	// client goes away; the worker then aborts GCS iteration on its own since
+	// it shares the request context.
+	type page struct {
+		listing     *Listing
+		body        *bytes.Buffer
+		notModified bool
+	}
+	// HTML shows relative timestamps, other formats only change with the entries.
+	var etag = func(listing *Listing) string {
+		if format.ContentType == htmlFormat.ContentType {
+			return ""
+		}
+		return listingETag(format, listing)
+	}
+	var done = make(chan page, 1)
+	go func() {
+		listing, err := cachedListDirectory(ctx, r.URL.Path, options)
+		if err != nil {
+			slog.Info("listing aborted", "path", r.URL.Path, "err", err)
+			done <- page{}
+			return
+		}
+		// Pollers revalidating a cached listing cost neither GCS calls nor rendering.
+		if tag := etag(listing); tag != "" && strings.TrimPrefix(r.Header.Get("If-None-Match"), "W/") == strings.TrimPrefix(tag, "W/") {
+			done <- page{listing: listing, notModified: true}
+			return
+		}
+		listing.links = linksFor(r)
+		listing.messages = messagesFor(r)
+		listing.skin = skinFor(r, listing.mountPoint)
+		listing.view = r.URL.Query().Get("view")
+		listing.types = r.URL.Query().Has("types")
+		listing.classes = r.URL.Query().Has("lifecycle")
+		listing.fields = options.Fields
+		sortListing(listing, r.URL.Query())
+		if options.Sorted {
+			pageSorted(listing, options.After, nameComparator(mountPoint, options.Natural))
+			listing.Start = r.URL.Query().Get("start")
+		}
+		paginate(listing, r.URL.Query())
+		expandLink(listing, r.URL.Query())
+		diskUsageLink(listing, r.URL.Query())
+		versionsLink(listing, r.URL.Query())
+		contentTypesLink(listing, r.URL.Query())
+		lifecycleLink(listing, r.URL.Query())
+		var body = new(bytes.Buffer)
+		format.Render(ctx, body, listing)
+		done <- page{listing: listing, body: body}
+	}()
+
+	select {
+	case <-ctx.Done():
+		slog.Debug("client disconnected", "path", r.URL.Path)
+	case page := <-done:
+		if page.listing == nil {
+			return
+		}
+		w.Header().Set("X-Index-Generation", fmt.Sprintf("%016x", page.listing.version))
+		if tag := etag(page.listing); tag != "" {
+			w.Header().Set("ETag", tag)
+		}
+		if page.notModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if page.listing.Next != "" {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", page.listing.Next))
+		}
+		if page.listing.Prev != "" {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"prev\"", page.listing.Prev))
+		}
+		if signListing(w, r, format, page.body) {
+			page.body.WriteTo(w)
+		}
+	}
+}
 
-	links = append(links, linksFromMountPoints(r.URL.Path)...)
+// alternateLink is a Link header value pointing to the same page in another
+// format.
+func alternateLink(query url.Values, format, contentType string) string {
+	var values = maps.Clone(query)
+	values.Del("callback")
+	values.Del("long")
+	values.Set("format", format)
+	return fmt.Sprintf("<?%s>; rel=\"alternate\"; type=\"%s\"", values.Encode(), contentType)
+}
 
-	var storageLinks, readmeObject = linksFromStorage(r.Context(), r.URL.Path)
-	links = append(links, storageLinks...)
+// listingETag is a weak ETag derived from the version of a listing, as
+// rendered in the given format. Pages, sorting and fields are in the URL.
+func listingETag(format ListingFormat, listing *Listing) string {
+	var hash = fnv.New64a()
+	fmt.Fprintf(hash, "%s\n%x\n", format.ContentType, listing.version)
+	return fmt.Sprintf("W/\"%x\"", hash.Sum64())
+}
 
-	links = slices.Compact(links)
-	slices.SortStableFunc(links, sortLinks)
+// listingVersion digests the names, generations and sizes of the entries of a
+// listing, along with where it stops.
+func listingVersion(listing *Listing) uint64 {
+	var hash = fnv.New64a()
+	fmt.Fprintf(hash, "%t\n%s\n%d\n", listing.Truncated, listing.Cursor, listing.Collapsed)
+	for _, item := range listing.Items {
+		fmt.Fprintf(hash, "%s\n%d\n%d\n%d\n%s\n%s\n%v\n", item.Name, item.generation, item.Size, item.Objects, item.Status, item.StorageClass, item.CustomTime)
+	}
+	return hash.Sum64()
+}
 
-	var output = bufio.NewWriter(w)
+// cachedListDirectory goes through the listing cache if enabled. The result
+// is a copy that can be modified.
+func cachedListDirectory(ctx context.Context, path string, options ListOptions) (*Listing, error) {
+	if *listingCacheTTL <= 0 {
+		return listDirectory(ctx, path, options)
+	}
 
-	output.Write(pageHtml)
-	output.WriteString("<main><table>\n")
-	if r.URL.Path != "/" {
-		output.WriteString("<tr><td><a href=\"../\">../</a></td></tr>\n")
+	// Listings shared by other processes are as old as when they were indexed.
+	var key = path + "?" + options.key()
+	cached, _, err := listingCache.Get(ctx, key,
+		func(listing *Listing, _ time.Time) bool {
+			return time.Since(listing.IndexedAt) < *listingCacheTTL
+		},
+		func(ctx context.Context) (*Listing, error) {
+			if listing := sharedListings.get(ctx, key); listing != nil && time.Since(listing.IndexedAt) < *listingCacheTTL {
+				return listing, nil
+			}
+			listing, err := listDirectory(ctx, path, options)
+			if err == nil {
+				sharedListings.put(key, listing)
+			}
+			return listing, err
+		})
+	if err != nil {
+		return nil, err
 	}
-	for i, link := range links {
-		// Split links with and without extra information into separate tables.
-		if i > 0 && links[i-1].Extra != "" && link.Extra == "" {
-			output.WriteString("</table><table>\n")
+
+	var listing = *cached
+	listing.Items = slices.Clone(cached.Items)
+	return &listing, nil
+}
+
+func listDirectory(ctx context.Context, path string, options ListOptions) (*Listing, error) {
+	var listing = &Listing{
+		Path:       path,
+		Query:      options.Query,
+		Recursive:  options.Recursive,
+		Start:      options.Start,
+		IndexedAt:  time.Now().UTC(),
+		mountPoint: findMountPoint(ctx, path),
+	}
+	if !options.AsOf.IsZero() {
+		listing.AsOf = &options.AsOf
+	}
+
+	if options.Start == "" && !options.Recursive {
+		for _, item := range itemsFromMountPoints(ctx, path) {
+			if options.match(item.Name) {
+				listing.Items = append(listing.Items, item)
+			}
 		}
-		// Skip the favicon link on the root page.
-		if link.Target == "favicon.ico" && r.URL.Path == "/" {
-			continue
+	}
+
+	if listing.mountPoint != nil {
+		storageItems, readmeObject, next, err := itemsFromStorage(ctx, listing.mountPoint, path, options)
+		if err != nil {
+			return nil, err
+		}
+		listing.Items = append(listing.Items, storageItems...)
+		if options.Query == "" {
+			listing.readme = readmeObject
+		}
+		if next != "" {
+			listing.Truncated = true
+			listing.Cursor = next
+		}
+		if options.DiskUsage && !options.Recursive && options.AsOf.IsZero() {
+			if err := addDiskUsage(ctx, listing); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	listing.Items = slices.Compact(listing.Items)
+	collapseListing(listing, options)
+	addLatestItem(listing, options)
+	slices.SortStableFunc(listing.Items, itemComparator("name", false, nameComparator(listing.mountPoint, options.Natural)))
+	listing.version = listingVersion(listing)
+
+	return listing, ctx.Err()
+}
+
+// paginate links the listing to its neighbour pages, keeping the other query
+// parameters. Cursors only go forward, so the previous page is only known when
+// the client comes from it.
+func paginate(listing *Listing, query url.Values) {
+	var link = func(start string, prev *string) string {
+		var values = maps.Clone(query)
+		values.Del("start")
+		values.Del("prev")
+		if start != "" {
+			values.Set("start", start)
+		}
+		if prev != nil {
+			values.Set("prev", *prev)
 		}
-		output.WriteString(fmt.Sprintf("<tr><td><a href=\"%s\">%s</a></td>%s</tr>\n", link.Target, link.Target, link.Extra))
+		if len(values) == 0 {
+			return "./"
+		}
+		return "?" + values.Encode()
 	}
-	output.WriteString("</table></main>")
 
-	if readmeObject != nil && *readme {
-		output.WriteString("\n<footer>\n")
-		renderReadme(r.Context(), output, readmeObject)
-		output.WriteString("</footer>")
+	listing.first = link("", nil)
+	if listing.Cursor != "" {
+		listing.Next = link(listing.Cursor, &listing.Start)
+	}
+	if query.Has("prev") {
+		listing.Prev = link(query.Get("prev"), nil)
 	}
+}
 
-	output.Flush()
+func renderHTML(ctx context.Context, output *bytes.Buffer, listing *Listing) {
+	var page = htmlPage{
+		Listing:       listing,
+		MountPoint:    listing.mountPoint,
+		Messages:      listing.messages,
+		Breadcrumbs:   breadcrumbs(listing.Path),
+		Search:        listing.mountPoint != nil,
+		DiskUsageLink: listing.diskUsage,
+		VersionsLink:  listing.versions,
+		ContentTypes:  listing.types,
+		TypesLink:     listing.typesLink,
+		Lifecycle:     listing.classes,
+		LifecycleLink: listing.classLink,
+		ExpandLink:    listing.expand,
+		FirstLink:     listing.first,
+		PerPage:       *maxEntries,
+		Indexed:       *listingCacheTTL > 0,
+		ThemeScript:   template.JS(themeScript),
+		TableScript:   template.JS(tableScript),
+		StylesheetURL: *cssURL,
+		ExtraCSS:      extraCSS,
+	}
+	for _, link := range listing.sortLinks {
+		page.SortLinks = append(page.SortLinks, htmlLink{Label: listing.messages[link[0]], Href: link[1]})
+	}
+	for i, item := range listing.Items {
+		if hiddenItem(listing, item) {
+			continue
+		}
+		page.Entries = append(page.Entries, htmlEntry{
+			Item:   item,
+			Href:   listing.links.Entry(item.Name) + listing.asOfQuery(item),
+			Kind:   itemKind(item),
+			Group:  listing.sections[i],
+			Split:  i > 0 && !listing.Items[i-1].Dir && item.Dir,
+			Badges: statusBadges(item.Status),
+		})
+	}
+	if listing.view == versionsView {
+		page.Entries = groupByVersion(page.Entries, listing.messages["other-files"])
+	}
+	if listing.readme != nil && listing.mountPoint.Readme {
+		var readme bytes.Buffer
+		renderReadme(ctx, &readme, listing.mountPoint, listing.readme)
+		page.Readme = template.HTML(readme.String())
+	}
+	if listing.mountPoint != nil {
+		var custom = dirPageFor(ctx, listing.mountPoint, listing.Path)
+		page.Header, page.Footer = custom.header, custom.footer
+		if custom.templates != nil {
+			// Falls back to the default page, rather than a truncated one.
+			var body bytes.Buffer
+			err := custom.templates[listing.skin].Execute(&body, page)
+			if err == nil {
+				output.Write(body.Bytes())
+				return
+			}
+			slog.Warn("failed to render directory template", "path", listing.Path, "err", err)
+		}
+	}
+	if err := skins[listing.skin].Execute(output, page); err != nil {
+		slog.Error("failed to render listing", "path", listing.Path, "err", err)
+	}
 }
 
-func linksFromMountPoints(path string) (links []Link) {
-	for _, mountPoint := range mountPoints {
-		if mountPoint.Path != path && strings.HasPrefix(mountPoint.Path, path) {
-			links = append(links, Link{strings.SplitAfterN(strings.TrimPrefix(mountPoint.Path, path), "/", 2)[0], ""})
+func itemsFromMountPoints(ctx context.Context, path string) (items []Item) {
+	for _, mountPoint := range getMountPoints(ctx) {
+		if mountPoint.Path != path && strings.HasPrefix(mountPoint.Path, path) && mountPoint.Privacy != privacyUnlisted {
+			items = append(items, Item{Name: strings.SplitAfterN(strings.TrimPrefix(mountPoint.Path, path), "/", 2)[0], Dir: true})
 		}
 	}
 	return
 }
 
-func linksFromStorage(ctx context.Context, path string) (links []Link, readme *storage.ObjectAttrs) {
-	var mountPoint = findMountPoint(path)
-	if mountPoint == nil {
-		return
+func itemsFromStorage(ctx context.Context, mountPoint *MountPoint, path string, options ListOptions) (items []Item, readme *storage.ObjectAttrs, next string, err error) {
+	var limit = *maxEntries
+	if options.Sorted {
+		limit = maxSortedEntries
 	}
+	var yield = func(item Item) {
+		items = append(items, item)
+	}
+	if options.AsOf.IsZero() {
+		readme, next, err = walkStorage(ctx, mountPoint, path, options, limit, yield)
+	} else {
+		next, err = walkVersions(ctx, mountPoint, path, options, limit, yield)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return items, readme, next, nil
+}
 
+// walkStorage passes the entries of a directory to yield as they come off the
+// GCS iterator, up to limit entries if positive, and returns where to resume.
+func walkStorage(ctx context.Context, mountPoint *MountPoint, path string, options ListOptions, limit int, yield func(Item)) (readme *storage.ObjectAttrs, next string, err error) {
+	var count int
 	bucket := client.Bucket(mountPoint.Bucket)
 	query := &storage.Query{
-		Prefix:    mountPoint.Prefix + strings.TrimPrefix(path, mountPoint.Path),
+		Prefix:    mountPoint.ObjectName(path),
 		Delimiter: "/",
 	}
+	// Recursive listings leave out the subtrees of marked directories, which
+	// other listings show without them being listable.
+	var marked []string
+	if options.Recursive {
+		query.Delimiter = ""
+		if marked, err = noIndexDirs(ctx, bucket, query.Prefix); err != nil {
+			return nil, "", err
+		}
+	} else {
+		// Placeholders of subdirectories come along, for their statuses.
+		query.IncludeTrailingDelimiter = true
+	}
+	if options.Start != "" {
+		query.StartOffset = query.Prefix + options.Start
+	}
 
 	slog.Debug("listing objects", "bucket", mountPoint.Bucket, "query", query)
 
+	defer observeListing(mountPoint, time.Now())
+
+	ctx, span := tracer.Start(ctx, "storage.Objects", trace.WithAttributes(
+		attribute.String("gcs.bucket", mountPoint.Bucket),
+		attribute.String("gcs.prefix", query.Prefix),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("gcs.items", count))
+		span.End()
+	}()
+
+	// Statuses of subdirectories by name, from their placeholders which come
+	// before their prefix in each page of results.
+	var dirStatuses = make(map[string]string)
 	objects := bucket.Objects(ctx, query)
 	for {
+		// Buffered pages don't consult the context, check it explicitly.
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+
 		attrs, err := objects.Next()
 		if err == iterator.Done {
 			break
 		} else if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, "", ctxErr
+			}
+			span.RecordError(err)
 			slog.Error("failed to list objects", "err", err)
+			return nil, "", err
+		}
+
+		// Stop listing once the cap is reached, remembering where to resume.
+		if limit > 0 && count >= limit {
+			next = strings.TrimPrefix(attrs.Name+attrs.Prefix, query.Prefix)
 			break
 		}
 
+		var name = strings.TrimPrefix(attrs.Name+attrs.Prefix, query.Prefix)
+		if query.IncludeTrailingDelimiter && attrs.Name != query.Prefix && strings.HasSuffix(attrs.Name, "/") {
+			dirStatuses[name] = parseStatus(attrs.Metadata)
+			continue
+		}
+		var status = dirStatuses[name]
+		if attrs.Name != "" {
+			status = parseStatus(attrs.Metadata)
+		}
+		if mountPoint.isStaged(attrs.Name+attrs.Prefix) || !options.match(name) || (attrs.Name != "" && !options.filter(name)) || (options.Stable && isPrerelease(name)) ||
+			(options.NoYanked && hasStatus(status, yankedStatus)) || inNoIndexDir(name, marked) {
+			continue
+		}
+
 		if attrs.Name != "" {
-			if strings.ToLower(attrs.Name) == "readme.md" {
+			if strings.ToLower(strings.TrimPrefix(attrs.Name, query.Prefix)) == "readme.md" {
 				readme = attrs
-				if *skipReadme {
+				if mountPoint.SkipReadme {
 					continue
 				}
 			}
 			if attrs.Name != query.Prefix {
-				links = append(links, Link{
-					strings.TrimPrefix(attrs.Name, query.Prefix),
-					fmt.Sprintf(
-						"<td>%s</td><td><time title=\"%s\">%s</time></td><td>%x</td>",
-						humanize.IBytes(uint64(attrs.Size)),
-						attrs.Updated.Format(time.DateTime),
-						humanize.Time(attrs.Updated),
-						attrs.MD5,
-					),
+				count++
+				yield(Item{
+					Name:         strings.TrimPrefix(attrs.Name, query.Prefix),
+					Size:         attrs.Size,
+					Updated:      &attrs.Updated,
+					MD5:          fmt.Sprintf("%x", attrs.MD5),
+					ContentType:  attrs.ContentType,
+					Status:       status,
+					StorageClass: attrs.StorageClass,
+					CustomTime:   customTime(attrs),
+					generation:   attrs.Generation,
 				})
 			}
 		} else if attrs.Prefix != "" {
-			links = append(links, Link{strings.TrimPrefix(attrs.Prefix, query.Prefix), ""})
+			count++
+			yield(Item{Name: strings.TrimPrefix(attrs.Prefix, query.Prefix), Dir: true, Status: status})
 		} else {
 			slog.Warn("unexpected object", "attrs", attrs)
 		}
@@ -134,26 +690,94 @@ func linksFromStorage(ctx context.Context, path string) (links []Link, readme *s
 	return
 }
 
-func sortLinks(a, b Link) int {
-	if aHasExtra, bHasExtra := a.Extra != "", b.Extra != ""; aHasExtra != bHasExtra {
-		if aHasExtra {
-			return -1
+// sortListing sorts the listing by ?sort=name|size|time and ?order=asc|desc.
+// Listings in orders other than the one of GCS hold the whole directory, see
+// sortedPaging.
+func sortListing(listing *Listing, query url.Values) {
+	var sortBy, order = query.Get("sort"), query.Get("order")
+	if !slices.Contains([]string{"name", "size", "time"}, sortBy) {
+		sortBy = ""
+	}
+	if order != "asc" && order != "desc" {
+		order = ""
+	}
+
+	// Links sort by a column, or reverse the order if already sorted by it.
+	for _, column := range []string{"name", "size", "time"} {
+		var values = maps.Clone(query)
+		values.Del("start") // Cursors only apply to the order they come from.
+		values.Del("prev")
+		values.Set("sort", column)
+		values.Set("order", "asc")
+		if column == cmp.Or(sortBy, "name") && order != "desc" {
+			values.Set("order", "desc")
 		}
-		return 1
+		listing.sortLinks = append(listing.sortLinks, [2]string{column, "?" + values.Encode()})
 	}
 
-	if *versionSort {
-		va, i := guessVersion(a.Target)
-		vb, j := guessVersion(b.Target)
-		if va != nil && vb != nil {
-			if cmp := strings.Compare(a.Target[:i], b.Target[:j]); cmp != 0 {
-				return cmp
+	if sortBy == "" && order == "" {
+		return
+	}
+	if sortBy == "" {
+		sortBy = "name"
+	}
+
+	slices.SortStableFunc(listing.Items, itemComparator(sortBy, order == "desc", nameComparator(listing.mountPoint, naturalSortFor(query, listing.mountPoint))))
+	listing.Sort, listing.Order = sortBy, order
+}
+
+// itemComparator orders files before directories, then by name, size or
+// time. Ties are broken by name, see nameComparator.
+func itemComparator(sortBy string, desc bool, compareNames func(a, b string) int) func(a, b Item) int {
+	return func(a, b Item) int {
+		if a.Dir != b.Dir {
+			if b.Dir {
+				return -1
 			}
-			if cmp := vb.Compare(va); cmp != 0 {
-				return cmp
+			return 1
+		}
+
+		var result int
+		switch sortBy {
+		case "size":
+			result = cmp.Compare(a.Size, b.Size)
+		case "time":
+			if a.Updated != nil && b.Updated != nil {
+				result = a.Updated.Compare(*b.Updated)
 			}
 		}
+		if result == 0 {
+			result = compareNames(a.Name, b.Name)
+		}
+		if desc {
+			return -result
+		}
+		return result
 	}
+}
 
-	return strings.Compare(a.Target, b.Target)
+// nameComparator returns how the names of a mount point compare: names with
+// the same prefix before their versions from the highest version to the
+// lowest with version sort, then with numbers compared as numbers with natural
+// sort, or lexicographically.
+func nameComparator(mountPoint *MountPoint, natural bool) func(a, b string) int {
+	var compare = strings.Compare
+	if natural {
+		compare = compareNatural
+	}
+	var versionScheme = versionSchemeFor(mountPoint)
+	if versionScheme == "" {
+		return compare
+	}
+	return func(a, b string) int {
+		if result, i, j, ok := compareVersions(a, b, versionScheme); ok {
+			if cmp := compare(a[:i], b[:j]); cmp != 0 {
+				return cmp
+			}
+			if result != 0 {
+				return -result
+			}
+		}
+		return compare(a, b)
+	}
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// benchmarkListing returns a listing of a release directory with the given
+// number of entries, a tenth of them directories, in GCS order.
+func benchmarkListing(n int) *Listing {
+	var updated = time.Date(2024, 5, 14, 18, 0, 0, 0, time.UTC)
+	var items = make([]Item, 0, n)
+	for i := range n {
+		if i%10 == 0 {
+			items = append(items, Item{Name: fmt.Sprintf("v1.%d.%d/", i/100, i%100), Dir: true})
+			continue
+		}
+		var modified = updated.Add(-time.Duration(i*7919%n) * time.Minute)
+		items = append(items, Item{
+			Name:        fmt.Sprintf("app-1.%d.%d.tar.gz", i/100, i%100),
+			Size:        int64(i * 7919 % 1000003),
+			Updated:     &modified,
+			MD5:         "d41d8cd98f00b204e9800998ecf8427e",
+			ContentType: "application/gzip",
+			generation:  int64(1715709600000000 + i),
+		})
+	}
+	return &Listing{
+		Path:      "/releases/",
+		Items:     items,
+		IndexedAt: updated,
+		links:     &Links{base: &url.URL{Scheme: "https", Host: "releases.example.com"}, root: "/", page: "/releases/"},
+		messages:  catalog["en"],
+		skin:      "table",
+		sections:  map[int]string{},
+	}
+}
+
+func BenchmarkSortListing(b *testing.B) {
+	var versioned = &MountPoint{Path: "/releases/", VersionSort: true, VersionScheme: semverScheme}
+	for _, sortBy := range []string{"name", "size", "time"} {
+		for _, mountPoint := range []*MountPoint{nil, versioned} {
+			var name = sortBy
+			if mountPoint != nil {
+				name += "/versions"
+			}
+			b.Run(name, func(b *testing.B) {
+				var unsorted = benchmarkListing(10000)
+				var query = url.Values{"sort": {sortBy}, "order": {"desc"}}
+				b.ResetTimer()
+				for range b.N {
+					b.StopTimer()
+					var listing = *unsorted
+					listing.Items = append([]Item(nil), unsorted.Items...)
+					listing.mountPoint = mountPoint
+					b.StartTimer()
+					sortListing(&listing, query)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRenderHTML(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			var listing = benchmarkListing(n)
+			var output bytes.Buffer
+			b.ReportAllocs()
+			for range b.N {
+				output.Reset()
+				renderHTML(context.Background(), &output, listing)
+			}
+			b.SetBytes(int64(output.Len()))
+		})
+	}
+}
+
+func BenchmarkRenderJSON(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			var listing = benchmarkListing(n)
+			var output bytes.Buffer
+			b.ReportAllocs()
+			for range b.N {
+				output.Reset()
+				renderJSON(context.Background(), &output, listing)
+			}
+			b.SetBytes(int64(output.Len()))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const jsonContentType = "application/json"
+const vendorJsonContentType = "application/vnd.gcs-index+json"
+
+// Only plain (possibly dotted) JavaScript identifiers are accepted as JSONP
+// callbacks, anything else could be used to inject script into the response.
+var jsonpCallbackRegexp = regexp.MustCompile(`^[A-Za-z_$][0-9A-Za-z_$]*(\.[A-Za-z_$][0-9A-Za-z_$]*)*$`)
+
+func negotiateFormat(r *http.Request) ListingFormat {
+	switch r.URL.Query().Get("format") {
+	case "html":
+		return htmlFormat
+	case "json":
+		return ListingFormat{ContentType: jsonContentType, Render: renderJSON}
+	case "nginx-json":
+		return nginxFormat
+	case "txt":
+		return textFormat(r.URL.Query().Has("long"))
+	case "atom":
+		return atomFormat
+	case "csv":
+		return csvFormat(',')
+	case "tsv":
+		return csvFormat('\t')
+	case "md5sum":
+		return md5sumFormat
+	case "ndjson":
+		return ListingFormat{ContentType: ndjsonContentType} // Streamed by streamNDJSON.
+	}
+
+	if callback := r.URL.Query().Get("callback"); callback != "" && *jsonp {
+		if jsonpCallbackRegexp.MatchString(callback) {
+			return jsonpFormat(callback)
+		}
+		slog.Warn("invalid jsonp callback", "callback", callback)
+	}
+
+	var htmlQuality, jsonQuality, textQuality float64
+	var jsonType = jsonContentType
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		var quality = 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		switch mediaType {
+		case jsonContentType:
+			jsonQuality = max(jsonQuality, quality)
+		case vendorJsonContentType:
+			if quality > jsonQuality {
+				jsonType = vendorJsonContentType
+			}
+			jsonQuality = max(jsonQuality, quality)
+		case textContentType:
+			textQuality = max(textQuality, quality)
+		case "text/html", "text/*", "*/*":
+			htmlQuality = max(htmlQuality, quality)
+		}
+	}
+
+	if jsonQuality > 0 && jsonQuality >= max(htmlQuality, textQuality) {
+		return ListingFormat{ContentType: jsonType, Render: renderJSON}
+	}
+	if textQuality > htmlQuality {
+		return textFormat(r.URL.Query().Has("long"))
+	}
+	return htmlFormat
+}
+
+func renderJSON(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+	for i := range listing.Items {
+		if listing.fields == nil || listing.fields["url"] {
+			listing.Items[i].URL = listing.links.Absolute(listing.Path+listing.Items[i].Name) + listing.asOfQuery(listing.Items[i])
+		}
+		listing.Items[i].selectFields(listing.fields)
+	}
+	if err := json.NewEncoder(w).Encode(listing); err != nil {
+		slog.Error("failed to encode listing", "err", err)
+	}
+}
+
+// JSONP is only kept around for legacy clients that can't do CORS; it is
+// entirely contained in this function and disabled unless -jsonp is set.
+func jsonpFormat(callback string) ListingFormat {
+	return ListingFormat{ContentType: "text/javascript", Render: func(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+		w.WriteString("/**/" + callback + "(")
+		renderJSON(ctx, w, listing)
+		w.WriteString(");\n")
+	}}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// latestName is the virtual entry of directories of versioned mount points
+// that points to their highest version.
+const latestName = "latest"
+
+// latestPath splits a path going through a latest entry into the versioned
+// directory and what follows the entry, if the mount point sorts versions.
+func latestPath(mountPoint *MountPoint, path string) (dir, rest string, ok bool) {
+	if mountPoint == nil || !mountPoint.VersionSort {
+		return "", "", false
+	}
+	if dir, ok = strings.CutSuffix(path, "/"+latestName); ok {
+		return dir + "/", "", len(dir)+1 >= len(mountPoint.Path)
+	}
+	dir, rest, ok = strings.Cut(path, "/"+latestName+"/")
+	return dir + "/", rest, ok && len(dir)+1 >= len(mountPoint.Path)
+}
+
+func isLatestPath(mountPoint *MountPoint, path string) bool {
+	_, _, ok := latestPath(mountPoint, path)
+	return ok
+}
+
+// latestItem returns the entry with the highest version, if any.
+func latestItem(items []Item, versionScheme string) (latest Item, ok bool) {
+	var highest = -1
+	for i, item := range items {
+		if strings.TrimSuffix(item.Name, "/") == latestName {
+			continue
+		}
+		if v, _ := guessVersion(item.Name); v == nil {
+			continue
+		}
+		if highest < 0 {
+			highest = i
+		} else if result, _, _, ok := compareVersions(item.Name, items[highest].Name, versionScheme); ok && result > 0 {
+			highest = i
+		}
+	}
+	if highest < 0 {
+		return Item{}, false
+	}
+	return items[highest], true
+}
+
+// addLatestItem adds the latest entry to complete listings of versioned
+// directories, as a copy of the entry it points to.
+func addLatestItem(listing *Listing, options ListOptions) {
+	if listing.mountPoint == nil || !listing.mountPoint.VersionSort || listing.Truncated || options.Start != "" || options.Query != "" {
+		return
+	}
+	if latest, ok := latestItem(listing.Items, versionSchemeFor(listing.mountPoint)); ok {
+		latest.Name = latestName
+		if latest.Dir {
+			latest.Name += "/"
+		}
+		listing.Items = append(listing.Items, latest)
+	}
+}
+
+// handleLatest redirects a path going through a latest entry to the highest
+// version of the directory, which is listed in full for that. Directories
+// without versions are served as usual.
+func handleLatest(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint) {
+	dir, rest, _ := latestPath(mountPoint, r.URL.Path)
+	// The redirect would tell what unlisted directories hold.
+	if mountPoint.NoListings || hasNoIndexMarker(r.Context(), mountPoint, dir) {
+		serveWithoutLatest(w, r)
+		return
+	}
+	options, err := listOptionsFor(r.URL.Query(), mountPoint)
+	if err != nil {
+		slog.Warn("invalid listing options", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	listing, err := cachedListDirectory(r.Context(), dir, ListOptions{Sorted: true, Stable: options.Stable, NoYanked: options.NoYanked})
+	if err != nil && r.Context().Err() != nil {
+		slog.Info("listing aborted", "path", dir, "err", err)
+		return
+	} else if err != nil {
+		slog.Error("failed to list directory", "path", dir, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	latest, ok := latestItem(listing.Items, versionSchemeFor(mountPoint))
+	switch {
+	case !ok:
+		serveWithoutLatest(w, r)
+		return
+	case !latest.Dir && (rest != "" || strings.HasSuffix(r.URL.Path, "/")):
+		notFound(w, r, mountPoint)
+		return
+	}
+
+	var target = linksFor(r).Absolute(dir + latest.Name + rest)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	slog.Info("redirecting to latest version", "path", r.URL.Path, "target", linksFor(r).Absolute(dir+latest.Name+rest))
+	w.Header().Set("Cache-Control", mountPoint.CacheControl)
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// serveWithoutLatest serves a path going through a latest entry as any other,
+// for directories without versions.
+func serveWithoutLatest(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		handleIndex(w, r)
+	} else {
+		handleObject(w, r)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// customTime returns the CustomTime of an object, which lifecycle rules may
+// key off, or nil if it has none.
+func customTime(attrs *storage.ObjectAttrs) *time.Time {
+	if attrs.CustomTime.IsZero() {
+		return nil
+	}
+	return &attrs.CustomTime
+}
+
+// lifecycleLink links HTML listings to themselves with the columns of storage
+// classes and custom times shown with ?lifecycle, or hidden again.
+func lifecycleLink(listing *Listing, query url.Values) {
+	if listing.mountPoint == nil {
+		return
+	}
+	var values = maps.Clone(query)
+	if listing.classes {
+		values.Del("lifecycle")
+	} else {
+		values.Set("lifecycle", "1")
+	}
+	listing.classLink = "?" + values.Encode()
+}
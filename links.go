@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Links turns request paths into URLs for generated output. Every output
+// format goes through it so that escaping and absolute URLs are handled the
+// same way everywhere.
+type Links struct {
+	base *url.URL // Scheme, host and path prefix of absolute URLs.
+	root string   // Request path that the base URL points to.
+	page string   // Request path of the page being rendered.
+}
+
+// linksFor returns the links for a request. Absolute URLs use the base URL of
+// the mount point (pointing to the mount path) or the global one (pointing to
+// the root) if set, otherwise they are derived from the request, honoring the
+// usual headers set by reverse proxies only if trusted: any client could
+// otherwise send them, and have links to another host cached for everyone.
+func linksFor(r *http.Request) *Links {
+	if baseURL, root := baseURLFor(r.Context(), r.URL.Path); baseURL != "" {
+		if base, err := url.Parse(baseURL); err == nil {
+			return &Links{base: base, root: root, page: r.URL.Path}
+		}
+	}
+
+	var base = &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	if !*trustForwarded {
+		return &Links{base: base, root: "/", page: r.URL.Path}
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		base.Scheme = proto
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		base.Host = strings.TrimSpace(strings.Split(host, ",")[0])
+	}
+	return &Links{base: base, root: "/", page: r.URL.Path}
+}
+
+// baseURLFor returns the configured base URL for a request path, empty if
+// none, and the request path that it points to.
+func baseURLFor(ctx context.Context, path string) (baseURL, root string) {
+	if mountPoint := findMountPoint(ctx, path); mountPoint != nil && mountPoint.BaseURL != *globalBaseURL {
+		return mountPoint.BaseURL, mountPoint.Path
+	}
+	return *globalBaseURL, "/"
+}
+
+// Entry returns a relative link to an entry of the page's directory.
+func (l *Links) Entry(name string) string {
+	var href = escapePath(name)
+	// A colon in the first segment would be taken for a URL scheme.
+	if strings.Contains(strings.SplitN(href, "/", 2)[0], ":") {
+		href = "./" + href
+	}
+	return href
+}
+
+// Path returns a link to any request path, relative to the page if the path
+// is below the page's directory.
+func (l *Links) Path(path string) string {
+	var dir = l.page[:strings.LastIndex(l.page, "/")+1]
+	if rest, ok := strings.CutPrefix(path, dir); ok && rest != "" {
+		return l.Entry(rest)
+	}
+	return escapePath(path)
+}
+
+// Absolute returns the absolute URL of a request path.
+func (l *Links) Absolute(path string) string {
+	var u = *l.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, l.root)
+	u.RawPath = ""
+	return u.String()
+}
+
+// escapePath escapes each segment of a path, keeping the slashes.
+func escapePath(path string) string {
+	var segments = strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// checkBaseURL validates a base URL given in the configuration.
+func checkBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid base URL %q: expected http(s)://host[/path]", baseURL)
+	}
+	return nil
+}
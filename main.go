@@ -12,153 +12,621 @@ import (
 	"os/signal"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/dustin/go-humanize"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type MountPoint struct {
 	Path   string
 	Bucket string
 	Prefix string
+
+	// Per-mount options, defaulting to the command-line flags.
+	Readme           bool
+	SkipReadme       bool
+	VersionSort      bool
+	VersionScheme    string
+	NaturalSort      bool
+	CacheControl     string
+	RedirectSigned   bool
+	BaseURL          string
+	DefaultDocuments []string
+	DefaultCharset   string
+	Skin             string
+	Robots           string
+	Writable         bool
+	WriteOnce        bool            // Existing objects are never overwritten.
+	NamingRules      []NamingRule    // Names of written objects must match all of them.
+	Scanner          Scanner         // Nil unless uploads must be scanned.
+	BasicAuth        *BasicAuth      // Nil unless configured.
+	OIDCAuth         *OIDCAuth       // Nil unless configured; public if both are nil.
+	DeleteApproval   *DeleteApproval // Nil if deletes don't need approval.
+	CORS             *CORS           // Nil unless cross-origin requests are allowed.
+	Staging          string          // Prefix where uploads wait to be promoted, relative to Prefix.
+	Collapse         []string        // Patterns of directories hidden from listings unless expanded.
+	Stable           bool            // Pre-releases are hidden unless ?stable=0.
+	NoListings       bool            // Directories only serve their default document.
+	NotFoundPage     string          // Object served with 404 responses, relative to the mount point.
+	SecurityHeaders  http.Header     // Shared, never mutated.
+	SecurityScope    string          // Responses the security headers are set on.
+	Privacy          string          // public, unlisted or private.
+	PrimeURL         string          // CDN URL template fetched to prime objects, see prime.go.
 }
 
 const defaultCacheControl = "public, max-age=60, must-revalidate"
 
+var methodOverrideHeaders = []string{"X-HTTP-Method-Override", "X-HTTP-Method", "X-Method-Override"}
+
 var client *storage.Client
-var mountPoints []MountPoint
+var mountPoints atomic.Pointer[[]MountPoint] // Swapped as a whole on reload, never mutated.
 
+var globalBaseURL = flag.String("base-url", "", "external base URL for absolute links (derived from requests by default)")
+var chaos = flag.String("chaos", "", "") // Hidden, see storageClientOptions.
+var configFile = flag.String("config", "", "load mount points and their options from a YAML file")
+var consulAddr = flag.String("consul-addr", "", "URL of the local Consul agent to register the instance with, e.g. http://127.0.0.1:8500 (disabled by default)")
+var consulService = flag.String("consul-service", "gcs-index", "name of the service registered with Consul")
+var corsHeaders = flag.String("cors-headers", "Authorization, Content-Type, If-Match, If-Modified-Since, If-None-Match, Range", "comma-separated request headers allowed in cross-origin requests")
+var corsMaxAge = flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache the answers to CORS preflight requests")
+var corsMethods = flag.String("cors-methods", "", "comma-separated methods allowed in cross-origin requests (those of each mount point by default)")
+var corsOrigins = flag.String("cors-origins", "", "comma-separated origins allowed to make cross-origin requests, or * for any (disabled by default)")
+var cssURL = flag.String("css-url", "", "stylesheet linked from HTML listings after their own styles, e.g. to brand colors and fonts")
+var defaultCharset = flag.String("default-charset", "", "charset appended to text content types of objects lacking one, e.g. utf-8")
+var defaultDocuments = flag.String("default-documents", "", "comma-separated objects served instead of directory listings when present, in priority order")
+var defaultLocale = flag.String("default-locale", "en", "language of HTML listings for clients without a supported Accept-Language (en, fr or de)")
+var diskCacheDir = flag.String("disk-cache", "", "directory to cache objects in (disabled by default)")
+var diskCacheMaxObjectSize = flag.String("disk-cache-max-object-size", "", "maximum size of a single object in the disk cache (no limit by default)")
+var diskCacheMinHits = flag.Int("disk-cache-min-hits", 1, "number of requests for an object before it is cached on disk")
+var diskCacheSize = flag.String("disk-cache-size", "10GiB", "maximum size of the disk cache")
+var drainDelay = flag.Duration("drain-delay", 5*time.Second, "time /drain on the metrics address waits, failing readiness, before answering")
+var extraCSSFile = flag.String("extra-css", "", "CSS file appended to the styles of HTML listings")
+var iapAllow = flag.String("iap-allow", "", "comma-separated emails and @domains allowed through Identity-Aware Proxy (any by default)")
+var iapAudience = flag.String("iap-audience", "", "validate Identity-Aware Proxy assertions for this audience (disabled by default)")
+var jsonErrors = flag.Bool("json-errors", false, "report fatal errors as JSON on stderr")
+var jsonp = flag.Bool("jsonp", false, "enable JSONP listings through the callback query parameter")
+var listingCacheSocket = flag.String("listing-cache-socket", "", "unix socket through which the processes of a host share their listing cache (disabled by default)")
+var listingCacheTTL = flag.Duration("listing-cache-ttl", 0, "cache directory listings in memory for this long (disabled by default)")
+var logRedact = flag.String("log-redact", "token,sig,signature,credential,key,secret,password", "comma-separated patterns of query parameters whose values are redacted from logs")
+var logSample = flag.Int("log-sample", 1, "log one in this many successful requests, failed ones are always logged")
+var maxEntries = flag.Int("max-entries", 10000, "maximum number of entries in a page of a directory listing (0 for no limit)")
+var metricsAddr = flag.String("metrics-addr", "", "address to serve Prometheus metrics on (disabled by default)")
+var naturalSort = flag.Bool("natural-sort", false, "sort numbers in names as numbers, e.g. build-9 before build-10")
+var notFoundPage = flag.String("not-found-page", "", "object served with 404 responses, relative to each mount point, e.g. 404.html")
+var otlpEndpoint = flag.String("otlp-endpoint", "", "OTLP/HTTP endpoint URL to export traces to (tracing is disabled by default)")
 var port = flag.Int("port", 8080, "port to listen on")
+var primeURLTemplate = flag.String("prime-url", "", "URL template of objects through a CDN, with {path} or {url}, fetched by POST ?action=prime (disabled by default)")
+var quiet = flag.Bool("quiet", false, "leave startup messages out of logs, the ready event on stdout telling when serving begins")
 var readme = flag.Bool("readme", false, "enable README.md rendering")
+var redirectSigned = flag.Bool("redirect-signed", false, "redirect object downloads to signed GCS URLs instead of proxying them")
+var reusePort = flag.Bool("reuseport", false, "set SO_REUSEPORT on TCP listeners, so that several instances can listen on the same port")
+var robots = flag.String("robots", "", "serve /robots.txt: allow, disallow, or the path of a file to serve (disabled by default)")
+var securityHeaders = flag.String("security-headers", securityHeadersListings, "responses to set security headers on: listings (HTML only), all (objects too) or none")
+var signedURLTTL = flag.Duration("signed-url-ttl", 15*time.Minute, "validity of signed download and upload URLs")
+var signingKeyFile = flag.String("signing-key", "", "PEM file of an Ed25519 private key to sign listings other than HTML with (disabled by default)")
+var sitemapTTL = flag.Duration("sitemap-ttl", 0, "serve /sitemap.xml for public mount points, rebuilt in the background after this long (disabled by default)")
+var skin = flag.String("skin", "table", "look of HTML listings: table, classic or cards")
 var skipReadme = flag.Bool("skip-readme", false, "skip README.md in directory listings")
 var socket = flag.String("socket", "", "socket to listen on")
+var socketGroup = flag.String("socket-group", "", "group, by name or ID, to give the socket file to")
+var socketMode = flag.String("socket-mode", "", "permissions to set on the socket file, in octal, e.g. 0660")
+var socketOwner = flag.String("socket-owner", "", "user, by name or ID, to give the socket file to")
 var socketUmask = flag.Int("socket-umask", -1, "umask for the socket file")
+var templateFile = flag.String("template", "", "html/template file replacing the page of HTML listings")
+var trustForwarded = flag.Bool("trust-forwarded-headers", false, "derive absolute links from X-Forwarded-Proto and X-Forwarded-Host, which the proxy in front must always set")
 var verbose = flag.Bool("v", false, "enable verbose logging")
+var versionScheme = flag.String("version-scheme", semverScheme, "scheme of versions for version sort: semver, or calver for date-based versions")
 var versionSort = flag.Bool("version-sort", false, "sort directory listings using a semver-aware algorithm")
 
 func main() {
-	flag.Parse()
+	flag.Usage = usage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fatal(exitUsage, "invalid command line", err)
+	}
 
 	if *verbose {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
+	if flag.Arg(0) == selftestCommand && flag.NArg() < 2 {
+		flag.Usage()
+		fatal(exitUsage, "missing path to test", nil)
+	}
+
+	if skins[*skin] == nil {
+		fatal(exitUsage, "unknown skin", fmt.Errorf("%q", *skin))
+	}
+	if err := setupTheme(); err != nil {
+		fatal(exitConfig, "invalid theme", err)
+	}
+	if *templateFile != "" {
+		if err := loadTemplate(*templateFile); err != nil {
+			fatal(exitConfig, "invalid template", err)
+		}
+	}
+	if err := checkVersionScheme(*versionScheme); err != nil {
+		fatal(exitUsage, "invalid version scheme", err)
+	}
+	if _, _, _, err := socketPermissions(); err != nil {
+		fatal(exitUsage, "invalid socket permissions", err)
+	}
+	if err := setupLogRedaction(*logRedact); err != nil {
+		fatal(exitUsage, "invalid log redaction pattern", err)
+	}
+	if _, err := parseChaos(*chaos); err != nil {
+		fatal(exitUsage, "invalid chaos", err)
+	}
+	if err := checkCORS(defaultCORS()); err != nil {
+		fatal(exitUsage, "invalid CORS origins", err)
+	}
+	if err := checkSecurityScope(*securityHeaders); err != nil {
+		fatal(exitUsage, "invalid security headers", err)
+	}
+	if err := checkPrimeURL(*primeURLTemplate); err != nil {
+		fatal(exitUsage, "invalid prime URL", err)
+	}
+	if err := checkConsul(); err != nil {
+		fatal(exitUsage, "invalid Consul registration", err)
+	}
+	if err := checkNotFoundPage(*notFoundPage); err != nil {
+		fatal(exitUsage, "invalid not-found page", err)
+	}
+	if *signingKeyFile != "" {
+		if err := loadSigningKey(*signingKeyFile); err != nil {
+			fatal(exitConfig, "invalid signing key", err)
+		}
+		logStartup("signing listings", "publicKey", signingPublicKey())
+	}
+	if err := checkRobots(); err != nil {
+		fatal(exitConfig, "invalid robots file", err)
+	}
+	if !setupLocales(*defaultLocale) {
+		fatal(exitUsage, "unsupported default locale", fmt.Errorf("%q", *defaultLocale))
+	}
 
 	prepareMountPoints()
-	slog.Info("initializing", "mountPoints", mountPoints)
+	logStartup("initializing", "mountPoints", getMountPoints(context.Background()))
 
 	var err error
-	client, err = storage.NewClient(context.Background(), storage.WithJSONReads())
+	clientOptions, err := storageClientOptions(context.Background())
 	if err != nil {
-		slog.Error("failed to create storage client", "err", err)
-		os.Exit(4)
+		fatal(exitCredentials, "failed to create storage client", err)
+	}
+	client, err = storage.NewClient(context.Background(), clientOptions...)
+	if err != nil {
+		fatal(exitCredentials, "failed to create storage client", err)
+	}
+
+	if *listingCacheSocket != "" && *listingCacheTTL > 0 {
+		sharedListings = newSharedListingCache(*listingCacheSocket)
+	}
+
+	if *diskCacheDir != "" {
+		maxSize, err := humanize.ParseBytes(*diskCacheSize)
+		if err != nil {
+			fatal(exitConfig, "invalid disk cache size", err)
+		}
+		var maxObjectSize uint64
+		if *diskCacheMaxObjectSize != "" {
+			if maxObjectSize, err = humanize.ParseBytes(*diskCacheMaxObjectSize); err != nil {
+				fatal(exitConfig, "invalid disk cache object size", err)
+			}
+		}
+		if objectCache, err = newDiskCache(*diskCacheDir, int64(maxSize), int64(maxObjectSize), *diskCacheMinHits); err != nil {
+			fatal(exitConfig, "failed to open disk cache", err)
+		}
+	}
+
+	if flag.Arg(0) == selftestCommand {
+		runSelftest(flag.Arg(1))
+		return
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal(exitConfig, "failed to set up tracing", err)
 	}
 
 	server := &http.Server{}
-	http.HandleFunc("/", handle)
+	http.Handle("/", otelhttp.NewHandler(instrument(handle), "request"))
+
+	var metricsListener net.Listener
+	if *metricsAddr != "" {
+		logStartup("serving metrics", "addr", *metricsAddr)
+		metricsListener, err = listenTCP(*metricsAddr)
+		if err != nil {
+			fatal(exitListen, "failed to listen for metrics", err)
+		}
+		go func() {
+			fatal(exitServe, "metrics server error", http.Serve(metricsListener, metricsHandler()))
+		}()
+	}
 
 	var listener net.Listener
 	if *socket != "" {
-		var oldUmask = -1
-		if *socketUmask >= 0 {
-			slog.Info("setting umask", "umask", *socketUmask)
-			oldUmask = syscall.Umask(*socketUmask)
-		}
-		slog.Info("listening on socket", "socket", *socket)
-		listener, err = net.Listen("unix", *socket)
-		if oldUmask >= 0 {
-			syscall.Umask(oldUmask)
-		}
+		logStartup("listening on socket", "socket", *socket)
+		listener, err = listenUnix(*socket)
 	} else {
-		slog.Info("listening on port", "port", *port)
-		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		logStartup("listening on port", "port", *port, "reusePort", *reusePort)
+		listener, err = listenTCP(fmt.Sprintf(":%d", *port))
 	}
 	if err != nil {
-		slog.Error("failed to listen", "err", err)
-		os.Exit(3)
+		fatal(exitListen, "failed to listen", err)
 	}
 
 	go func() {
 		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
-			slog.Error("server error", "err", err)
-			os.Exit(5)
+			fatal(exitServe, "server error", err)
 		}
 		slog.Warn("server stopped")
 	}()
 
-	// Wait for a signal to stop the server
+	if *consulAddr != "" {
+		if err := registerConsul(context.Background()); err != nil {
+			fatal(exitRegister, "failed to register with Consul", err)
+		}
+		logStartup("registered with Consul", "service", *consulService, "id", consulServiceID())
+	}
+	announceReady(listener, metricsListener)
+
+	// Wait for a signal to stop the server, reloading mount points on SIGHUP
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 
-	<-sigChan
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		slog.Info("reloading mount points")
+		reloadMountPoints()
+	}
 	slog.Warn("shutting down server")
+	draining.Store(true)
 
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownRelease()
 
+	if *consulAddr != "" {
+		if err := deregisterConsul(shutdownCtx); err != nil {
+			slog.Warn("failed to deregister from Consul", "err", err)
+		}
+	}
+
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		slog.Error("shutdown error", "err", err)
-		os.Exit(6)
+		fatal(exitShutdown, "shutdown error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("failed to flush traces", "err", err)
 	}
 	slog.Info("shutdown completed")
 }
 
 func prepareMountPoints() {
-	args := flag.Args()
-	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s path:bucket:prefix [path:bucket:prefix ...]\n", os.Args[0])
-		os.Exit(1)
+	if len(mountPointArgs()) < 1 && *configFile == "" {
+		flag.Usage()
+		fatal(exitUsage, "no mount points", nil)
+	}
+
+	result, err := loadMountPoints()
+	if err != nil {
+		fatal(exitConfig, "invalid mount point", err)
+	}
+	mountPoints.Store(&result)
+}
+
+// reloadMountPoints swaps the mount point table for a freshly loaded one. The
+// current table is kept if the new one is invalid; requests in flight keep
+// using the table they started with.
+func reloadMountPoints() {
+	result, err := loadMountPoints()
+	if err != nil {
+		slog.Error("failed to reload mount points", "err", err)
+		return
+	}
+	mountPoints.Store(&result)
+	listingCache.Clear()
+	sharedListings.clear()
+	slog.Info("reloaded mount points", "mountPoints", result)
+}
+
+func loadMountPoints() ([]MountPoint, error) {
+	if err := checkBaseURL(*globalBaseURL); err != nil {
+		return nil, err
+	}
+
+	var result []MountPoint
+	if *configFile != "" {
+		configured, err := loadConfig(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, configured...)
+	}
+
+	parsed, err := parseMountPoints(mountPointArgs())
+	if err != nil {
+		return nil, err
 	}
+	result = append(result, parsed...)
+
+	return checkMountPoints(result)
+}
 
+func splitList(list string) (result []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return
+}
+
+type mountPointsKey struct{}
+
+// withMountPoints has the request use the current mount points throughout,
+// even if they are reloaded meanwhile.
+func withMountPoints(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), mountPointsKey{}, mountPoints.Load()))
+}
+
+// getMountPoints returns the mount points of the request, or the current ones
+// outside of requests.
+func getMountPoints(ctx context.Context) []MountPoint {
+	if table, ok := ctx.Value(mountPointsKey{}).(*[]MountPoint); ok {
+		return *table
+	}
+	return *mountPoints.Load()
+}
+
+func parseMountPoints(args []string) ([]MountPoint, error) {
+	var result []MountPoint
 	for _, arg := range args {
 		mountPointParts := strings.SplitN(arg, ":", 3)
 		if len(mountPointParts) != 3 {
-			slog.Error("invalid mount point", "arg", arg, "reason", "expected 'path:bucket:prefix'")
-			os.Exit(2)
+			return nil, fmt.Errorf("%q: expected 'path:bucket:prefix'", arg)
 		}
 
-		// Normalize the path
-		if !strings.HasPrefix(mountPointParts[0], "/") {
-			mountPointParts[0] = "/" + mountPointParts[0]
+		prefix, options, _ := strings.Cut(mountPointParts[2], "?")
+		mc, err := parseMountOptions(options)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", arg, err)
 		}
-		if !strings.HasSuffix(mountPointParts[0], "/") {
-			mountPointParts[0] += "/"
+		mountPoint, err := newMountPoint(mountPointParts[0], mountPointParts[1], prefix)
+		if err == nil {
+			err = mc.apply(&mountPoint)
 		}
-
-		mountPoints = append(mountPoints, MountPoint{
-			Path:   mountPointParts[0],
-			Bucket: mountPointParts[1],
-			Prefix: mountPointParts[2],
-		})
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", arg, err)
+		}
+		result = append(result, mountPoint)
 	}
+	return result, nil
+}
 
+// checkMountPoints sorts mount points for lookup and validates them as a set.
+func checkMountPoints(result []MountPoint) ([]MountPoint, error) {
 	// Longest path first
-	slices.SortFunc(mountPoints, func(a, b MountPoint) int {
+	slices.SortFunc(result, func(a, b MountPoint) int {
 		if len(a.Path) != len(b.Path) {
 			return len(b.Path) - len(a.Path)
 		} else {
 			return strings.Compare(a.Path, b.Path)
 		}
 	})
+
+	for i := 1; i < len(result); i++ {
+		if result[i].Path == result[i-1].Path {
+			return nil, fmt.Errorf("duplicate mount point %q", result[i].Path)
+		}
+	}
+
+	// Exposing the same objects under two paths is most likely a mistake,
+	// unless a mount point is nested in another one at the same place, to
+	// give part of it other settings.
+	for i, a := range result {
+		for _, b := range result[i+1:] {
+			if a.Bucket != b.Bucket || !strings.HasPrefix(a.Prefix, b.Prefix) && !strings.HasPrefix(b.Prefix, a.Prefix) {
+				continue
+			}
+			// Longest path first: a can only be nested in b.
+			if rest, ok := strings.CutPrefix(a.Path, b.Path); ok && a.Prefix == b.Prefix+rest {
+				continue
+			}
+			return nil, fmt.Errorf("mount points %q and %q overlap in bucket %q", b.Path, a.Path, a.Bucket)
+		}
+	}
+
+	return result, nil
+}
+
+func newMountPoint(path, bucket, prefix string) (MountPoint, error) {
+	// Normalize the path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	if strings.Contains(path, "//") || slices.Contains(strings.Split(path, "/"), "..") {
+		return MountPoint{}, fmt.Errorf("invalid path %q", path)
+	}
+
+	if bucket == "" {
+		return MountPoint{}, errors.New("missing bucket name")
+	}
+
+	// Normalize the prefix, which is either empty or a "directory"
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if strings.HasPrefix(prefix, "/") {
+		return MountPoint{}, fmt.Errorf("prefix %q must not start with '/'", prefix)
+	}
+
+	return MountPoint{
+		Path:             path,
+		Bucket:           bucket,
+		Prefix:           prefix,
+		Readme:           *readme,
+		SkipReadme:       *skipReadme,
+		VersionSort:      *versionSort,
+		VersionScheme:    *versionScheme,
+		NaturalSort:      *naturalSort,
+		CacheControl:     defaultCacheControl,
+		RedirectSigned:   *redirectSigned,
+		BaseURL:          *globalBaseURL,
+		DefaultDocuments: splitList(*defaultDocuments),
+		DefaultCharset:   *defaultCharset,
+		Skin:             *skin,
+		Robots:           defaultRobotsPolicy(),
+		NotFoundPage:     *notFoundPage,
+		CORS:             defaultCORS(),
+		SecurityHeaders:  defaultSecurityHeaders,
+		SecurityScope:    *securityHeaders,
+		Privacy:          privacyPublic,
+		PrimeURL:         *primeURLTemplate,
+	}, nil
 }
 
 func handle(w http.ResponseWriter, r *http.Request) {
-	slog.Info("request", "path", r.URL.Path, "method", r.Method)
+	if err := checkURLSize(r.URL); err != nil {
+		slog.Warn("invalid request URL", "method", r.Method, "pathLength", len(r.URL.EscapedPath()), "queryLength", len(r.URL.RawQuery), "err", err)
+		if err == errTooLong {
+			w.WriteHeader(http.StatusRequestURITooLong)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
 
-	if r.Method != http.MethodGet && r.Method != http.MethodHead {
-		slog.Warn("method not allowed", "method", r.Method)
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	if isS3Listing(r) {
+		r.URL.Path, r.URL.RawPath = s3ListingPath(r), ""
+	}
+
+	var logArgs = []any{"path", r.URL.Path, "method", r.Method}
+	var start = time.Now()
+	var recorder = w
+	defer func() { logRequest(recorder, r, start, logArgs) }()
+	if *iapAudience != "" {
+		email, err := checkIAP(r)
+		if err != nil {
+			slog.Warn("invalid IAP assertion", "path", r.URL.Path, "err", err)
+			countIAP("", "invalid")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !iapAllowed(email) {
+			slog.Warn("denied by IAP allow list", "path", r.URL.Path, "user", email)
+			countIAP(email, "denied")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		countIAP(email, "allowed")
+		logArgs = append(logArgs, "user", email)
+		r = withUser(r, email)
+	}
+
+	var mountPoint = findMountPoint(r.Context(), r.URL.Path)
+	setNoIndex(w.Header(), mountPoint)
+	if !handleCORS(w, r, mountPoint) {
+		return
+	}
+	var allowed = allowedMethods(mountPoint)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		if !slices.Contains(allowed, r.Method) {
+			slog.Warn("method not allowed", "method", r.Method)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+	default:
+		// TRACE, CONNECT and extension methods are not supported at all.
+		slog.Warn("method not implemented", "method", r.Method)
+		w.WriteHeader(http.StatusNotImplemented)
 		return
 	}
 
-	if strings.HasSuffix(r.URL.Path, "/") {
+	// Method overrides are never honored, refuse them rather than silently
+	// serving a GET to a client that believes it did something else.
+	for _, header := range methodOverrideHeaders {
+		if r.Header.Get(header) != "" {
+			slog.Warn("method override refused", "header", header, "value", r.Header.Get(header))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := checkPath(r.URL); err != nil {
+		slog.Warn("invalid path", "path", r.URL.EscapedPath(), "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Staged uploads are only reachable once promoted.
+	if mountPoint != nil && mountPoint.isStaged(mountPoint.ObjectName(r.URL.Path)) {
+		notFound(w, r, mountPoint)
+		return
+	}
+
+	if mountPoint != nil {
+		var ok bool
+		if r, ok = authenticate(w, r, mountPoint); !ok {
+			return
+		}
+	}
+
+	// Shared caches must not serve to others what credentials gave access to.
+	if requestUser(r) != "" {
+		var vary []string
+		if *iapAudience != "" {
+			vary = append(vary, "X-Goog-IAP-JWT-Assertion")
+		}
+		if mountPoint != nil && (mountPoint.BasicAuth != nil || mountPoint.OIDCAuth != nil) {
+			vary = append(vary, "Authorization")
+		}
+		w = &privateResponse{ResponseWriter: w, vary: vary}
+	}
+
+	switch {
+	case r.Method == http.MethodDelete:
+		handleDelete(w, r)
+	case r.Method == http.MethodPatch:
+		handlePatch(w, r)
+	case r.Method == http.MethodPost:
+		handlePost(w, r)
+	case r.Method == http.MethodPut:
+		handlePut(w, r)
+	case r.URL.Path == "/robots.txt" && robotsEnabled(r.Context()):
+		handleRobots(w, r)
+	case *sitemapTTL > 0 && sitemapShard(r.URL.Path) >= 0:
+		handleSitemap(w, r)
+	case isS3Listing(r):
+		handleS3List(w, r)
+	case isLatestPath(mountPoint, r.URL.Path):
+		handleLatest(w, r, mountPoint)
+	case strings.HasSuffix(r.URL.Path, "/"):
 		handleIndex(w, r)
-	} else {
+	default:
 		handleObject(w, r)
 	}
 }
 
-func findMountPoint(path string) *MountPoint {
+// allowedMethods returns the methods supported by the mount point.
+func allowedMethods(mountPoint *MountPoint) []string {
+	if mountPoint != nil && mountPoint.Writable {
+		return []string{http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodPatch, http.MethodPost, http.MethodPut}
+	}
+	return []string{http.MethodGet, http.MethodHead}
+}
+
+func findMountPoint(ctx context.Context, path string) *MountPoint {
+	var mountPoints = getMountPoints(ctx)
 	for i := 0; i < len(mountPoints); i++ {
 		if strings.HasPrefix(path, mountPoints[i].Path) {
 			return &mountPoints[i]
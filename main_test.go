@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckMountPoints(t *testing.T) {
+	type mount struct{ path, bucket, prefix string }
+	var tests = []struct {
+		name   string
+		mounts []mount
+		ok     bool
+	}{
+		{"distinct buckets", []mount{{"/a/", "one", ""}, {"/b/", "two", ""}}, true},
+		{"disjoint prefixes", []mount{{"/a/", "one", "a/"}, {"/b/", "one", "b/"}}, true},
+		{"prefix of a name", []mount{{"/a/", "one", "rel"}, {"/b/", "one", "release/"}}, true},
+		{"nested at the same place", []mount{{"/", "one", ""}, {"/internal/", "one", "internal/"}}, true},
+		{"nested deeper", []mount{{"/", "one", ""}, {"/a/b/", "one", "a/b/"}}, true},
+		{"nested below a prefix", []mount{{"/x/", "one", "data/"}, {"/x/y/", "one", "data/y/"}, {"/", "two", ""}}, true},
+		{"nested elsewhere", []mount{{"/", "one", ""}, {"/internal/", "one", "other/"}}, false},
+		{"nested in another bucket", []mount{{"/", "one", ""}, {"/internal/", "two", ""}}, true},
+		{"empty prefixes", []mount{{"/a/", "one", ""}, {"/b/", "one", ""}}, false},
+		{"empty prefix and subdirectory", []mount{{"/a/", "one", ""}, {"/b/", "one", "b/"}}, false},
+		{"unnormalized prefixes", []mount{{"/a/", "one", "rel"}, {"/b/", "one", "rel/"}}, false},
+		{"outer mount below inner prefix", []mount{{"/", "one", "a/"}, {"/b/", "one", ""}}, false},
+		{"duplicate paths", []mount{{"/a/", "one", ""}, {"/a/", "two", ""}}, false},
+		{"unnormalized paths", []mount{{"a", "one", ""}, {"/a/", "two", ""}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mountPoints []MountPoint
+			for _, m := range test.mounts {
+				mountPoint, err := newMountPoint(m.path, m.bucket, m.prefix)
+				if err != nil {
+					t.Fatalf("newMountPoint(%q, %q, %q): %v", m.path, m.bucket, m.prefix, err)
+				}
+				mountPoints = append(mountPoints, mountPoint)
+			}
+			_, err := checkMountPoints(mountPoints)
+			if test.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !test.ok && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestCheckMountPointsOrder(t *testing.T) {
+	var mountPoints []MountPoint
+	for _, path := range []string{"/", "/a/b/", "/b/", "/a/"} {
+		mountPoint, err := newMountPoint(path, "bucket-"+path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	mountPoints, err := checkMountPoints(mountPoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = []string{"/a/b/", "/a/", "/b/", "/"}
+	for i, mountPoint := range mountPoints {
+		if mountPoint.Path != want[i] {
+			t.Errorf("mount point %d is %q, want %q", i, mountPoint.Path, want[i])
+		}
+	}
+}
+
+func TestHandleMethods(t *testing.T) {
+	setMountPoints(t, [3]string{"/", "public", ""})
+
+	var tests = []struct {
+		name    string
+		method  string
+		header  http.Header
+		status  int
+		allowed string
+	}{
+		{"trace", http.MethodTrace, nil, http.StatusNotImplemented, ""},
+		{"connect", http.MethodConnect, nil, http.StatusNotImplemented, ""},
+		{"extension method", "PROPFIND", nil, http.StatusNotImplemented, ""},
+		{"put on a read-only mount", http.MethodPut, nil, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"delete on a read-only mount", http.MethodDelete, nil, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"post on a read-only mount", http.MethodPost, nil, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"method override", http.MethodGet, http.Header{"X-Http-Method-Override": {"DELETE"}}, http.StatusBadRequest, ""},
+		{"method override variant", http.MethodGet, http.Header{"X-Method-Override": {"PUT"}}, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r = httptest.NewRequest(test.method, "/releases/app.tar.gz", nil)
+			for name, values := range test.header {
+				r.Header[name] = values
+			}
+			var w = httptest.NewRecorder()
+			handle(w, r)
+			if w.Code != test.status {
+				t.Errorf("status is %d, want %d", w.Code, test.status)
+			}
+			if allow := w.Header().Get("Allow"); allow != test.allowed {
+				t.Errorf("Allow is %q, want %q", allow, test.allowed)
+			}
+		})
+	}
+}
+
+func TestRequestKeepsMountPoints(t *testing.T) {
+	setMountPoints(t, [3]string{"/releases/", "old", ""})
+	var r = withMountPoints(httptest.NewRequest(http.MethodGet, "/releases/", nil))
+
+	// As on SIGHUP.
+	var reloaded = []MountPoint{{Path: "/releases/", Bucket: "new"}}
+	mountPoints.Store(&reloaded)
+
+	if mountPoint := findMountPoint(r.Context(), r.URL.Path); mountPoint == nil || mountPoint.Bucket != "old" {
+		t.Errorf("request in flight got %+v, want the old mount point", mountPoint)
+	}
+	if mountPoint := findMountPoint(context.Background(), "/releases/"); mountPoint == nil || mountPoint.Bucket != "new" {
+		t.Errorf("got %+v outside of requests, want the new mount point", mountPoint)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// md5sumFormat renders the checksums of the objects of a listing as md5sum
+// does, for mirrors to check their copies with md5sum -c. Composite objects,
+// which have no MD5, are left out.
+var md5sumFormat = ListingFormat{ContentType: textContentType + "; charset=utf-8", Render: func(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+	for _, item := range listing.Items {
+		if item.Dir || item.MD5 == "" {
+			continue
+		}
+		// Like md5sum, names with special characters are escaped and the line
+		// starts with a backslash.
+		var name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(item.Name)
+		if name != item.Name {
+			w.WriteString("\\")
+		}
+		fmt.Fprintf(w, "%s  %s\n", item.MD5, name)
+	}
+}}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// memoryCache is an in-memory cache that never makes requests wait for a
+// refresh: stale values are served as is while a single background goroutine
+// fetches a new one, and concurrent misses share the same fetch.
+//
+// Entries are evicted oldest first once their total cost goes over maxCost.
+type memoryCache[V any] struct {
+	name         string
+	maxCost      int
+	maxEntryCost int // Larger values are returned but not kept.
+	cost         func(V) int
+
+	mu      sync.Mutex
+	total   int
+	entries map[string]*memoryCacheEntry[V]
+}
+
+type memoryCacheEntry[V any] struct {
+	value      V
+	err        error
+	fetched    time.Time
+	cost       int
+	loaded     chan struct{} // Closed once the first fetch completed.
+	refreshing bool
+	waiters    int                // Requests waiting for the first fetch.
+	cancel     context.CancelFunc // Cancels the first fetch.
+}
+
+func newMemoryCache[V any](name string, maxCost, maxEntryCost int, cost func(V) int) *memoryCache[V] {
+	return &memoryCache[V]{
+		name:         name,
+		maxCost:      maxCost,
+		maxEntryCost: maxEntryCost,
+		cost:         cost,
+		entries:      make(map[string]*memoryCacheEntry[V]),
+	}
+}
+
+// Get returns the value for the key, and whether it came from the cache. Only
+// misses wait for fetch, as long as ctx allows, and the fetch is cancelled once
+// no request waits for it anymore; values that aren't fresh anymore are
+// refreshed in the background.
+func (c *memoryCache[V]) Get(ctx context.Context, key string, fresh func(V, time.Time) bool, fetch func(context.Context) (V, error)) (V, bool, error) {
+	c.mu.Lock()
+	entry, cached := c.entries[key]
+	if !cached {
+		// The fetch is shared with concurrent misses, it must not fail for all
+		// of them when the request that started it goes away, only once they
+		// all did.
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		entry = &memoryCacheEntry[V]{loaded: make(chan struct{}), cancel: cancel}
+		c.entries[key] = entry
+		go c.load(loadCtx, key, entry, fetch)
+	}
+	if entry.fetched.IsZero() {
+		entry.waiters++
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.loaded:
+	case <-ctx.Done():
+		c.leave(key, entry)
+		var zero V
+		return zero, false, ctx.Err()
+	}
+	if entry.err != nil || !cached {
+		return entry.value, false, entry.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !fresh(entry.value, entry.fetched) && !entry.refreshing {
+		entry.refreshing = true
+		go c.refresh(key, entry, fetch)
+	}
+	return entry.value, true, nil
+}
+
+// leave stops waiting for the first fetch of an entry, which is cancelled and
+// forgotten if nobody else waits for it.
+func (c *memoryCache[V]) leave(key string, entry *memoryCacheEntry[V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.waiters--
+	if entry.waiters == 0 && entry.fetched.IsZero() {
+		entry.cancel()
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *memoryCache[V]) load(ctx context.Context, key string, entry *memoryCacheEntry[V], fetch func(context.Context) (V, error)) {
+	defer entry.cancel()
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	value, err := fetch(ctx)
+
+	c.mu.Lock()
+	entry.value, entry.err, entry.fetched = value, err, time.Now()
+	if c.entries[key] == entry {
+		if err == nil && c.cost(value) <= c.maxEntryCost {
+			entry.cost = c.cost(value)
+			c.total += entry.cost
+			c.evict()
+		} else {
+			// Waiters still get the result, later requests fetch again.
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+	close(entry.loaded)
+}
+
+func (c *memoryCache[V]) refresh(key string, entry *memoryCacheEntry[V], fetch func(context.Context) (V, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	value, err := fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.refreshing = false
+	if err != nil {
+		slog.Warn("failed to refresh cache entry", "cache", c.name, "key", key, "err", err)
+		return
+	}
+	if c.entries[key] != entry {
+		return // Evicted or cleared meanwhile.
+	}
+
+	var cost = c.cost(value)
+	if cost > c.maxEntryCost {
+		c.drop(key, entry)
+		return
+	}
+	c.total += cost - entry.cost
+	entry.value, entry.fetched, entry.cost = value, time.Now(), cost
+	c.evict()
+}
+
+// Clear drops all entries, fetches in flight complete without being kept.
+func (c *memoryCache[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.total = 0
+}
+
+// Forget drops the entry for the key, which the next Get fetches again.
+func (c *memoryCache[V]) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		c.drop(key, entry)
+	}
+}
+
+// drop must be called with the lock held.
+func (c *memoryCache[V]) drop(key string, entry *memoryCacheEntry[V]) {
+	if c.entries[key] == entry {
+		delete(c.entries, key)
+		c.total -= entry.cost
+	}
+}
+
+// evict must be called with the lock held.
+func (c *memoryCache[V]) evict() {
+	for c.total > c.maxCost {
+		var oldestKey string
+		var oldest *memoryCacheEntry[V]
+		for key, entry := range c.entries {
+			if !entry.fetched.IsZero() && (oldest == nil || entry.fetched.Before(oldest.fetched)) {
+				oldestKey, oldest = key, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		c.drop(oldestKey, oldest)
+		slog.Debug("evicted cache entry", "cache", c.name, "key", oldestKey)
+	}
+}
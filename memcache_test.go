@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheSharedLoad(t *testing.T) {
+	var cache = newMemoryCache("test", 100, 100, func(string) int { return 1 })
+	var started, cancelled = make(chan struct{}), make(chan struct{})
+	var fetch = func(ctx context.Context) (string, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return "", ctx.Err()
+	}
+	var fresh = func(string, time.Time) bool { return true }
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	var errs = make(chan error, 2)
+	go func() {
+		_, _, err := cache.Get(first, "key", fresh, fetch)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, _, err := cache.Get(second, "key", fresh, fetch)
+		errs <- err
+	}()
+
+	// The load goes on as long as a request waits for it.
+	time.Sleep(10 * time.Millisecond)
+	cancelFirst()
+	<-errs
+	select {
+	case <-cancelled:
+		t.Fatal("load cancelled with the first request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelSecond()
+	<-errs
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("load not cancelled once no request waits for it")
+	}
+
+	// Later requests fetch again rather than getting the cancelled load.
+	value, _, err := cache.Get(context.Background(), "key", fresh, func(context.Context) (string, error) {
+		return "value", nil
+	})
+	if err != nil || value != "value" {
+		t.Errorf("got %q, %v after a cancelled load", value, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcs_index_requests_total",
+		Help: "Number of HTTP requests by mount point, method and status code.",
+	}, []string{"mount", "method", "code"})
+
+	responseBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcs_index_response_bytes_total",
+		Help: "Number of response body bytes served by mount point.",
+	}, []string{"mount"})
+
+	listingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gcs_index_listing_duration_seconds",
+		Help:    "Time spent listing objects in GCS by mount point.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"mount"})
+
+	iapRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcs_index_iap_requests_total",
+		Help: "Number of requests checked against Identity-Aware Proxy by email domain and result (allowed, denied or invalid).",
+	}, []string{"domain", "result"})
+
+	readmeCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcs_index_readme_cache_total",
+		Help: "Number of README cache lookups by mount point and result (hit or miss).",
+	}, []string{"mount", "result"})
+)
+
+// metricsHandler serves the Prometheus metrics endpoint, along with reports
+// for operators.
+func metricsHandler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.Handle("/", promhttp.Handler())
+	mux.HandleFunc("/gc-report", handleGCReport)
+	mux.HandleFunc("/ready", handleReady)
+	mux.HandleFunc("/drain", handleDrain)
+	return mux
+}
+
+// instrument wraps a handler to count requests and bytes served.
+func instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = withMountPoints(r)
+		var rec = &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		var mount = mountLabel(r.Context(), r.URL.Path)
+		requestsTotal.WithLabelValues(mount, r.Method, strconv.Itoa(rec.status)).Inc()
+		responseBytesTotal.WithLabelValues(mount).Add(float64(rec.bytes))
+	}
+}
+
+func observeListing(mountPoint *MountPoint, start time.Time) {
+	listingDuration.WithLabelValues(mountPoint.Path).Observe(time.Since(start).Seconds())
+}
+
+func countReadmeCache(mountPoint *MountPoint, hit bool) {
+	var result = "miss"
+	if hit {
+		result = "hit"
+	}
+	readmeCacheTotal.WithLabelValues(mountPoint.Path, result).Inc()
+}
+
+// countIAP labels by domain only, emails would make too many series.
+func countIAP(email string, result string) {
+	iapRequestsTotal.WithLabelValues(emailDomain(email), result).Inc()
+}
+
+func mountLabel(ctx context.Context, path string) string {
+	if mountPoint := findMountPoint(ctx, path); mountPoint != nil {
+		return mountPoint.Path
+	}
+	return ""
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	pathpkg "path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// NamingRule is a pattern that the names of objects written to a mount point
+// must match, relative to the mount path.
+type NamingRule struct {
+	Pattern *regexp.Regexp
+	Message string
+}
+
+func newNamingRule(pattern, message string) (NamingRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return NamingRule{}, fmt.Errorf("naming rule: %w", err)
+	}
+	if message == "" {
+		message = fmt.Sprintf("name must match %q", pattern)
+	}
+	return NamingRule{re, message}, nil
+}
+
+// checkName answers the request with a 422 and returns false if the object at
+// the path breaks a naming rule of the mount point, or with a 403 if it would
+// customize the listing page of its directory for every visitor.
+func checkName(w http.ResponseWriter, mountPoint *MountPoint, path string) bool {
+	var name = strings.TrimPrefix(path, mountPoint.Path)
+	if slices.Contains(dirPageObjects, pathpkg.Base(name)) {
+		slog.Warn("refusing to write directory page object", "path", path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "reserved name", "name": name})
+		return false
+	}
+	for _, rule := range mountPoint.NamingRules {
+		if !rule.Pattern.MatchString(name) {
+			slog.Warn("naming rule violated", "path", path, "rule", rule.Pattern.String())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			encoder := json.NewEncoder(w)
+			encoder.SetEscapeHTML(false)
+			encoder.Encode(map[string]string{"error": rule.Message, "name": name})
+			return false
+		}
+	}
+	return true
+}
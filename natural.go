@@ -0,0 +1,60 @@
+package main
+
+import (
+	"cmp"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// naturalSortFor tells whether listings sort naturally, from ?natural= or the
+// mount point.
+func naturalSortFor(query url.Values, mountPoint *MountPoint) bool {
+	if natural, err := strconv.ParseBool(query.Get("natural")); err == nil {
+		return natural
+	}
+	if mountPoint != nil {
+		return mountPoint.NaturalSort
+	}
+	return *naturalSort
+}
+
+// compareNatural compares names with runs of digits compared as numbers, so
+// that build-9 comes before build-10. Numbers equal but for leading zeros
+// compare lexicographically.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			var na, nb = digitsPrefix(a), digitsPrefix(b)
+			var ta, tb = strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return cmp.Compare(len(ta), len(tb))
+			}
+			if result := strings.Compare(ta, tb); result != 0 {
+				return result
+			}
+			if result := strings.Compare(na, nb); result != 0 {
+				return result
+			}
+			a, b = a[len(na):], b[len(nb):]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func digitsPrefix(s string) string {
+	var i int
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonFlushInterval is the number of entries after which the response is
+// flushed, so that clients get entries while GCS is still listing.
+const ndjsonFlushInterval = 1000
+
+// streamNDJSON writes the entries of a directory as one JSON object per line
+// as they come off the GCS iterator, without paging nor buffering, so that
+// huge directories don't have to fit in memory. Nested mount points come
+// first; the listing cache, sorting and README are not involved.
+func streamNDJSON(w http.ResponseWriter, r *http.Request, options ListOptions) {
+	ctx, span := tracer.Start(r.Context(), "streamNDJSON")
+	defer span.End()
+
+	var links = linksFor(r)
+	// Only used for the links to the entries of listings as of a past time.
+	var listing = &Listing{}
+	if !options.AsOf.IsZero() {
+		listing.AsOf = &options.AsOf
+	}
+	var output = bufio.NewWriter(w)
+	var encoder = json.NewEncoder(output)
+	var count int
+	var write = func(item Item) {
+		if options.Fields == nil || options.Fields["url"] {
+			item.URL = links.Absolute(r.URL.Path+item.Name) + listing.asOfQuery(item)
+		}
+		item.selectFields(options.Fields)
+		if err := encoder.Encode(item); err != nil {
+			slog.Error("failed to encode item", "err", err)
+		}
+		if count++; count%ndjsonFlushInterval == 0 {
+			output.Flush()
+			http.NewResponseController(w).Flush()
+		}
+	}
+
+	if options.Start == "" && !options.Recursive {
+		for _, item := range itemsFromMountPoints(ctx, r.URL.Path) {
+			if options.match(item.Name) {
+				write(item)
+			}
+		}
+	}
+
+	if mountPoint := findMountPoint(ctx, r.URL.Path); mountPoint != nil {
+		var err error
+		if options.AsOf.IsZero() {
+			_, _, err = walkStorage(ctx, mountPoint, r.URL.Path, options, 0, write)
+		} else {
+			_, err = walkVersions(ctx, mountPoint, r.URL.Path, options, 0, write)
+		}
+		if err != nil {
+			slog.Info("listing aborted", "path", r.URL.Path, "err", err)
+			return
+		}
+	}
+	output.Flush()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nginxFormat renders listings like nginx's autoindex_format json, for tools
+// that already parse it. Directories have no modification time in GCS, they get
+// the Unix epoch.
+var nginxFormat = ListingFormat{ContentType: jsonContentType, Render: renderNginxJSON}
+
+func renderNginxJSON(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+	w.WriteString("[")
+	for i, item := range listing.Items {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		var kind, mtime = "file", time.Unix(0, 0)
+		if item.Dir {
+			kind = "directory"
+		}
+		if item.Updated != nil {
+			mtime = *item.Updated
+		}
+		w.WriteString("\n{ \"name\":")
+		writeNginxString(w, strings.TrimSuffix(item.Name, "/"))
+		w.WriteString(", \"type\":\"" + kind + "\", \"mtime\":\"" + mtime.UTC().Format(http.TimeFormat) + "\"")
+		if !item.Dir {
+			w.WriteString(", \"size\":")
+			w.WriteString(strconv.FormatInt(item.Size, 10))
+		}
+		w.WriteString(" }")
+	}
+	w.WriteString("\n]\n")
+}
+
+// writeNginxString writes a JSON string without escaping HTML characters, as
+// nginx does.
+func writeNginxString(w *bytes.Buffer, s string) {
+	var encoder = json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	w.Truncate(w.Len() - 1) // Encode adds a newline.
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// noIndexMarker is the object that keeps its directory from being listed.
+const noIndexMarker = ".noindex"
+
+// noIndexMarkers tells by directory whether it holds a marker.
+var noIndexMarkers = newMemoryCache("noindex", 100000, 1, func(bool) int {
+	return 1
+})
+
+// hasNoIndexMarker tells whether the directory holds a .noindex marker,
+// looked up at most once a minute. Directories whose marker can't be looked up
+// are taken as marked.
+func hasNoIndexMarker(ctx context.Context, mountPoint *MountPoint, path string) bool {
+	var obj = client.Bucket(mountPoint.Bucket).Object(mountPoint.ObjectName(path + noIndexMarker))
+	marked, _, err := noIndexMarkers.Get(ctx, obj.BucketName()+"/"+obj.ObjectName(), func(_ bool, fetched time.Time) bool {
+		return time.Since(fetched) < time.Minute
+	}, func(ctx context.Context) (bool, error) {
+		_, err := obj.Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		slog.Error("failed to look up noindex marker", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+		return true
+	}
+	return marked
+}
+
+// noIndexDirs returns the directories below a prefix, relative to it, that
+// hold a .noindex marker, so that walks through their parents leave them out.
+// The prefix itself is "" when marked.
+func noIndexDirs(ctx context.Context, bucket *storage.BucketHandle, prefix string) ([]string, error) {
+	var query = &storage.Query{Prefix: prefix, MatchGlob: "**" + noIndexMarker}
+	query.SetAttrSelection([]string{"Name"})
+	var dirs []string
+	objects := bucket.Objects(ctx, query)
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return dirs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if name := strings.TrimPrefix(attrs.Name, prefix); path.Base(name) == noIndexMarker {
+			dirs = append(dirs, strings.TrimSuffix(name, noIndexMarker))
+		}
+	}
+}
+
+// inNoIndexDir tells whether a name relative to the prefix of noIndexDirs lies
+// in one of the marked directories.
+func inNoIndexDir(name string, dirs []string) bool {
+	return slices.ContainsFunc(dirs, func(dir string) bool {
+		return strings.HasPrefix(name, dir)
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxNotFoundPageSize bounds the not-found pages read from buckets.
+const maxNotFoundPageSize = 1 << 20
+
+// errorPage is the page of a mount point served with 404 responses, empty
+// if the mount point has none or its object is missing.
+type errorPage struct {
+	content     []byte
+	contentType string
+}
+
+var notFoundPages = newMemoryCache("not-found-page", 16*maxNotFoundPageSize, maxNotFoundPageSize, func(page errorPage) int {
+	return len(page.content)
+})
+
+func checkNotFoundPage(name string) error {
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return fmt.Errorf("invalid not-found page %q", name)
+	}
+	return nil
+}
+
+// notFound answers 404 with the not-found page of the mount point, if any.
+// Paths outside any mount point get the page of the top-most public mount
+// point having one. Pages of mount points requiring authentication are only
+// served to authenticated requests.
+func notFound(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint) {
+	if mountPoint == nil {
+		var mountPoints = getMountPoints(r.Context())
+		for i := len(mountPoints) - 1; i >= 0; i-- {
+			if mountPoints[i].NotFoundPage != "" && !requiresAuth(&mountPoints[i]) {
+				mountPoint = &mountPoints[i]
+				break
+			}
+		}
+	} else if requiresAuth(mountPoint) && requestUser(r) == "" {
+		mountPoint = nil
+	}
+	if mountPoint == nil || mountPoint.NotFoundPage == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var obj = client.Bucket(mountPoint.Bucket).Object(mountPoint.ObjectName(mountPoint.Path + mountPoint.NotFoundPage))
+	page, _, err := notFoundPages.Get(r.Context(), obj.BucketName()+"/"+obj.ObjectName(), func(_ errorPage, fetched time.Time) bool {
+		return time.Since(fetched) < time.Minute
+	}, func(ctx context.Context) (errorPage, error) {
+		return readNotFoundPage(ctx, obj)
+	})
+	if err != nil {
+		slog.Error("failed to read not-found page", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+	}
+	if len(page.content) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var h = w.Header()
+	h.Set("Content-Type", withCharset(page.contentType, mountPoint.DefaultCharset))
+	h.Set("Content-Length", strconv.Itoa(len(page.content)))
+	h.Set("Cache-Control", "no-cache")
+	setSecurityHeaders(h, mountPoint, true)
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		w.Write(page.content)
+	}
+}
+
+func readNotFoundPage(ctx context.Context, obj *storage.ObjectHandle) (errorPage, error) {
+	reader, err := obj.NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		slog.Warn("missing not-found page", "bucket", obj.BucketName(), "object", obj.ObjectName())
+		return errorPage{}, nil
+	} else if err != nil {
+		return errorPage{}, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(io.LimitReader(reader, maxNotFoundPageSize+1))
+	if err != nil {
+		return errorPage{}, err
+	}
+	if len(content) > maxNotFoundPageSize {
+		return errorPage{}, fmt.Errorf("not-found page larger than %d bytes", maxNotFoundPageSize)
+	}
+	var contentType = reader.Attrs.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	return errorPage{content, contentType}, nil
+}
+
+// requiresAuth tells whether the mount point is only served to authenticated
+// requests.
+func requiresAuth(mountPoint *MountPoint) bool {
+	return mountPoint.BasicAuth != nil || mountPoint.OIDCAuth != nil || mountPoint.Privacy == privacyPrivate
+}
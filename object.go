@@ -1,38 +1,123 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/iterator"
 )
 
 func handleObject(w http.ResponseWriter, r *http.Request) {
-	var mountPoint = findMountPoint(r.URL.Path)
+	ctx, span := tracer.Start(r.Context(), "handleObject")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
 	if mountPoint == nil {
-		w.WriteHeader(http.StatusNotFound)
+		if isDirectory(ctx, nil, r.URL.Path) {
+			redirectToDirectory(w, r, defaultCacheControl)
+			return
+		}
+		notFound(w, r, nil)
 		return
 	}
 
 	bucket := client.Bucket(mountPoint.Bucket)
-	obj := bucket.Object(mountPoint.Prefix + strings.TrimPrefix(r.URL.Path, mountPoint.Path))
+	obj := bucket.Object(name)
+	span.SetAttributes(attribute.String("gcs.bucket", mountPoint.Bucket), attribute.String("gcs.object", name))
+
+	// Older generations are available on buckets with object versioning.
+	if value := r.URL.Query().Get("generation"); value != "" {
+		generation, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || generation <= 0 {
+			slog.Warn("invalid generation", "path", r.URL.Path, "generation", value)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		obj = obj.Generation(generation)
+		span.SetAttributes(attribute.Int64("gcs.generation", generation))
+	}
 
-	attrs, err := obj.Attrs(r.Context())
+	attrsCtx, attrsSpan := tracer.Start(ctx, "storage.Attrs")
+	attrs, err := obj.Attrs(attrsCtx)
+	attrsSpan.End()
+	if errors.Is(err, storage.ErrObjectNotExist) && isDirectory(ctx, mountPoint, r.URL.Path) {
+		redirectToDirectory(w, r, mountPoint.CacheControl)
+		return
+	}
 	if err != nil {
+		span.RecordError(err)
 		slog.Error("failed to get object attributes",
 			"bucket", obj.BucketName(),
 			"object", obj.ObjectName(),
 			"err", err)
-		w.WriteHeader(http.StatusNotFound)
+		notFound(w, r, mountPoint)
 		return
 	}
 
+	serveObject(w, r, mountPoint, obj, attrs)
+}
+
+// isDirectory tells whether a path without its trailing slash is a directory:
+// a nested mount point, or a prefix of objects in the bucket.
+func isDirectory(ctx context.Context, mountPoint *MountPoint, path string) bool {
+	if nested := findMountPoint(ctx, path+"/"); nested != nil && nested.Path == path+"/" {
+		return true
+	}
+	if mountPoint == nil || strings.HasSuffix(path, "/") {
+		return false
+	}
+	var query = &storage.Query{Prefix: mountPoint.ObjectName(path) + "/"}
+	query.SetAttrSelection([]string{"Name"})
+	_, err := client.Bucket(mountPoint.Bucket).Objects(ctx, query).Next()
+	if err != nil && err != iterator.Done {
+		slog.Error("failed to look for directory", "bucket", mountPoint.Bucket, "prefix", query.Prefix, "err", err)
+	}
+	return err == nil
+}
+
+// redirectToDirectory adds the missing trailing slash to the path of a
+// directory. The redirect is cached, so it is only absolute when the base URL
+// is configured rather than derived from request headers.
+func redirectToDirectory(w http.ResponseWriter, r *http.Request, cacheControl string) {
+	var target = escapePath(r.URL.Path + "/")
+	if baseURL, _ := baseURLFor(r.Context(), r.URL.Path); baseURL != "" {
+		target = linksFor(r).Absolute(r.URL.Path + "/")
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// serveObject answers a request with an object whose attributes are known.
+func serveObject(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs) {
+	var ctx = r.Context()
 	var h = w.Header()
 
+	if redirectLink(w, r, mountPoint, attrs.Metadata) {
+		return
+	}
+	if serveYanked(w, mountPoint, attrs.Metadata) {
+		return
+	}
+	setSecurityHeaders(h, mountPoint, true)
+
 	h.Set("ETag", fmt.Sprintf("\"%s\"", attrs.Etag))
 	h.Set("Last-Modified", attrs.Updated.Format(http.TimeFormat))
+	h.Set("X-Goog-Generation", strconv.FormatInt(attrs.Generation, 10))
 
 	// Conditional requests
 	if inm := r.Header.Get("If-None-Match"); inm != "" {
@@ -49,13 +134,23 @@ func handleObject(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if mountPoint.RedirectSigned {
+		var generation int64
+		if r.URL.Query().Has("generation") {
+			generation = attrs.Generation
+		}
+		redirectToSignedURL(w, r, client.Bucket(obj.BucketName()), obj.ObjectName(), generation)
+		return
+	}
+
 	// Set headers
 	h.Set("Content-Length", fmt.Sprintf("%d", attrs.Size))
-	setHeaderIfNotEmpty(h, "Content-Type", attrs.ContentType)
+	setHeaderIfNotEmpty(h, "Content-Type", withCharset(attrs.ContentType, mountPoint.DefaultCharset))
 	setHeaderIfNotEmpty(h, "Content-Encoding", attrs.ContentEncoding)
+	setHeaderIfNotEmpty(h, "Content-Language", attrs.ContentLanguage)
 	setHeaderIfNotEmpty(h, "Content-Disposition", attrs.ContentDisposition)
 	if !setHeaderIfNotEmpty(h, "Cache-Control", attrs.CacheControl) {
-		h.Set("Cache-Control", defaultCacheControl)
+		h.Set("Cache-Control", mountPoint.CacheControl)
 	}
 
 	for k, v := range attrs.Metadata {
@@ -68,9 +163,25 @@ func handleObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var cacheKey string
+	if objectCache != nil {
+		cacheKey = diskCacheKey(obj.BucketName(), obj.ObjectName(), attrs.Generation)
+		if file := objectCache.Open(cacheKey); file != nil {
+			defer file.Close()
+			slog.Info("serving cached object", "bucket", obj.BucketName(), "object", obj.ObjectName())
+			// ServeContent takes care of ranges and sets the matching length.
+			h.Del("Content-Length")
+			http.ServeContent(w, r, "", attrs.Updated, file)
+			return
+		}
+	}
+
 	slog.Info("serving object", "bucket", obj.BucketName(), "object", obj.ObjectName())
-	reader, err := obj.NewReader(r.Context())
+	readCtx, readSpan := tracer.Start(ctx, "storage.Read")
+	defer readSpan.End()
+	reader, err := obj.NewReader(readCtx)
 	if err != nil {
+		readSpan.RecordError(err)
 		slog.Error("failed to read object",
 			"bucket", obj.BucketName(),
 			"object", obj.ObjectName(),
@@ -83,10 +194,62 @@ func handleObject(w http.ResponseWriter, r *http.Request) {
 	// Reset Content-Length (just in case?)
 	h.Set("Content-Length", fmt.Sprintf("%d", reader.Attrs.Size))
 
-	if _, err := io.Copy(w, reader); err != nil {
+	// Populate the disk cache while streaming complete objects, as far as the
+	// cache disk allows.
+	var output io.Writer = w
+	var cacheWriter *diskCacheWriter
+	if cacheKey != "" && r.Header.Get("Range") == "" {
+		if cacheWriter = objectCache.Create(cacheKey, reader.Attrs.Size); cacheWriter != nil {
+			output = io.MultiWriter(w, cacheWriter)
+		}
+	}
+
+	// Headers are sent by then, a failure can only cut the body short.
+	if _, err := io.Copy(output, reader); err != nil {
+		readSpan.RecordError(err)
 		slog.Error("failed to write object", "err", err)
+		if cacheWriter != nil {
+			cacheWriter.Abort()
+		}
+	} else if cacheWriter != nil {
+		cacheWriter.Commit(reader.Attrs.Size)
+	}
+}
+
+// redirectToSignedURL sends the client straight to GCS with a short-lived
+// signed URL, so that object bytes don't go through this process. The URL is
+// pinned to the generation unless it is zero.
+func redirectToSignedURL(w http.ResponseWriter, r *http.Request, bucket *storage.BucketHandle, name string, generation int64) {
+	var options = &storage.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(*signedURLTTL),
+		Scheme:  storage.SigningSchemeV4,
+	}
+	if generation != 0 {
+		options.QueryParameters = url.Values{"generation": {strconv.FormatInt(generation, 10)}}
+	}
+	url, err := bucket.SignedURL(name, options)
+	if err != nil {
+		slog.Error("failed to sign url", "bucket", bucket.BucketName(), "object", name, "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The redirect must not outlive the signature.
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(signedURLTTL.Seconds()/2)))
+	slog.Info("redirecting to signed url", "bucket", bucket.BucketName(), "object", name)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// withCharset appends the charset to text content types that lack one.
+func withCharset(contentType, charset string) string {
+	if charset == "" || !strings.HasPrefix(contentType, "text/") {
+		return contentType
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err != nil || params["charset"] != "" {
+		return contentType
 	}
+	return contentType + "; charset=" + charset
 }
 
 func setHeaderIfNotEmpty(h http.Header, key, value string) bool {
@@ -96,3 +259,22 @@ func setHeaderIfNotEmpty(h http.Header, key, value string) bool {
 	}
 	return false
 }
+
+// findDefaultDocument looks for the first default document of the mount point
+// existing in the directory at the given request path.
+func findDefaultDocument(ctx context.Context, mountPoint *MountPoint, path string) (*storage.ObjectHandle, *storage.ObjectAttrs) {
+	bucket := client.Bucket(mountPoint.Bucket)
+	for _, document := range mountPoint.DefaultDocuments {
+		obj := bucket.Object(mountPoint.ObjectName(path + document))
+		attrs, err := obj.Attrs(ctx)
+		if err == nil {
+			return obj, attrs
+		} else if !errors.Is(err, storage.ErrObjectNotExist) {
+			slog.Error("failed to get default document attributes",
+				"bucket", obj.BucketName(),
+				"object", obj.ObjectName(),
+				"err", err)
+		}
+	}
+	return nil, nil
+}
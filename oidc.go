@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+var errForbidden = errors.New("claims don't match the path rules")
+
+// OIDCAuth protects a mount point with bearer tokens issued by an OpenID
+// Connect provider, optionally requiring claims for some paths.
+type OIDCAuth struct {
+	verifier *oidc.IDTokenVerifier
+	rules    []ClaimRule // Longest path first.
+}
+
+// ClaimRule requires claims for requests under a path. A claim matches if it
+// equals the value, or if it is a list containing the value.
+type ClaimRule struct {
+	Path   string            `yaml:"path"`
+	Claims map[string]string `yaml:"claims"`
+}
+
+// newOIDCAuth discovers the keys of the issuer, unless a JWKS URL is given.
+func newOIDCAuth(issuer, jwksURL, audience string, rules []ClaimRule) (*OIDCAuth, error) {
+	if issuer == "" {
+		return nil, errors.New("oidc: missing issuer")
+	}
+	if audience == "" {
+		return nil, errors.New("oidc: missing audience")
+	}
+	var auth = &OIDCAuth{rules: slices.Clone(rules)}
+	for i, rule := range auth.rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("oidc: rule path %q must start with '/'", rule.Path)
+		}
+		// Rules match whole segments: /internal/ops doesn't cover /internal/opsx.
+		if !strings.HasSuffix(rule.Path, "/") {
+			auth.rules[i].Path += "/"
+		}
+	}
+
+	var config = &oidc.Config{ClientID: audience}
+	if jwksURL != "" {
+		auth.verifier = oidc.NewVerifier(issuer, oidc.NewRemoteKeySet(context.Background(), jwksURL), config)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		provider, err := oidc.NewProvider(ctx, issuer)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+		auth.verifier = provider.Verifier(config)
+	}
+
+	slices.SortFunc(auth.rules, func(a, b ClaimRule) int {
+		return len(b.Path) - len(a.Path)
+	})
+	return auth, nil
+}
+
+// Check verifies the token and the claims required for the path, and returns
+// the authenticated user along with the claims of the token.
+func (a *OIDCAuth) Check(ctx context.Context, token string, path string) (string, map[string]any, error) {
+	idToken, err := a.verifier.Verify(ctx, token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return "", nil, err
+	}
+
+	var user = idToken.Subject
+	if email, ok := claims["email"].(string); ok && email != "" {
+		user = email
+	}
+	if !a.allows(claims, path) {
+		return user, claims, errForbidden
+	}
+	return user, claims, nil
+}
+
+// allows tells whether claims match those required by the rule of the path,
+// if any.
+func (a *OIDCAuth) allows(claims map[string]any, path string) bool {
+	for _, rule := range a.rules {
+		if !strings.HasPrefix(path, rule.Path) && path+"/" != rule.Path {
+			continue
+		}
+		for name, value := range rule.Claims {
+			if !claimMatches(claims[name], value) {
+				return false
+			}
+		}
+		break
+	}
+	return true
+}
+
+type claimsKey struct{}
+
+// withClaims records the claims of a bearer token in the request context, for
+// requests reaching other paths than their own.
+func withClaims(r *http.Request, claims map[string]any) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+}
+
+// pathAllowed tells whether a request may read a path below its own, such as
+// the objects of an archive, under the rules of the mount point. Only bearer
+// tokens are subject to rules.
+func pathAllowed(r *http.Request, mountPoint *MountPoint, path string) bool {
+	claims, ok := r.Context().Value(claimsKey{}).(map[string]any)
+	return !ok || mountPoint.OIDCAuth == nil || mountPoint.OIDCAuth.allows(claims, path)
+}
+
+func claimMatches(claim any, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []any:
+		return slices.Contains(claim, any(value))
+	default:
+		return fmt.Sprint(claim) == value
+	}
+}
+
+func (a *OIDCAuth) challenge(invalidToken bool) string {
+	if invalidToken {
+		return `Bearer error="invalid_token"`
+	}
+	return "Bearer"
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Limits on request URLs. GCS object names are at most 1024 bytes long, so
+// anything much larger can't possibly match an object.
+const (
+	maxPathLength   = 2048
+	maxPathSegments = 128
+	maxQueryLength  = 2048
+)
+
+var errTooLong = errors.New("request URL too long")
+
+// checkURLSize rejects oversized request URLs before they are logged or turned
+// into storage API calls.
+func checkURLSize(u *url.URL) error {
+	if len(u.EscapedPath()) > maxPathLength || len(u.RawQuery) > maxQueryLength {
+		return errTooLong
+	}
+	if strings.Count(u.Path, "/") > maxPathSegments {
+		return errors.New("too many segments in path")
+	}
+	return nil
+}
+
+// checkPath rejects request paths that are not in canonical form. Paths are
+// percent-decoded exactly once by net/http; anything that would decode into a
+// different set of segments (encoded slashes), walk the tree (dot segments,
+// empty segments) or smuggle control characters into object names is refused
+// rather than normalized, so that a request can never reach outside of the
+// prefix of its mount point.
+func checkPath(u *url.URL) error {
+	if !strings.HasPrefix(u.Path, "/") {
+		return errors.New("path must be absolute")
+	}
+
+	if strings.Contains(strings.ToLower(u.EscapedPath()), "%2f") {
+		return errors.New("encoded slash in path")
+	}
+
+	for _, c := range u.Path {
+		if c < 0x20 || c == 0x7f {
+			return errors.New("invalid character in path")
+		}
+	}
+
+	var segments = strings.Split(strings.TrimSuffix(u.Path[1:], "/"), "/")
+	for _, segment := range segments {
+		switch {
+		case segment == "" && u.Path != "/":
+			return errors.New("empty segment in path")
+		case segment == "." || segment == "..":
+			return errors.New("dot segment in path")
+		}
+	}
+
+	return nil
+}
+
+// resolvePath maps a request path to the mount point serving it and to the
+// matching object name, which is a listing prefix for directory paths. This is
+// the only place where request paths are turned into object names.
+func resolvePath(ctx context.Context, path string) (*MountPoint, string) {
+	var mountPoint = findMountPoint(ctx, path)
+	if mountPoint == nil {
+		return nil, ""
+	}
+	return mountPoint, mountPoint.ObjectName(path)
+}
+
+// ObjectName returns the object name for a request path below the mount point.
+func (m *MountPoint) ObjectName(path string) string {
+	return m.Prefix + strings.TrimPrefix(path, m.Path)
+}
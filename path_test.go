@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setMountPoints serves the given mount points for the duration of the test.
+func setMountPoints(t *testing.T, mounts ...[3]string) {
+	t.Helper()
+	var result []MountPoint
+	for _, m := range mounts {
+		mountPoint, err := newMountPoint(m[0], m[1], m[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, mountPoint)
+	}
+	result, err := checkMountPoints(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var previous = mountPoints.Load()
+	mountPoints.Store(&result)
+	t.Cleanup(func() { mountPoints.Store(previous) })
+}
+
+func TestCheckPath(t *testing.T) {
+	setMountPoints(t, [3]string{"/", "public", ""}, [3]string{"/releases/", "private", "releases/"})
+
+	var tests = []struct {
+		uri string
+		ok  bool
+	}{
+		{"/", true},
+		{"/releases/", true},
+		{"/releases/1.2.3/app.tar.gz", true},
+		{"/releases/.well-known/", true},
+		{"/releases/..tar.gz", true},
+		{"/releases/a%20b", true},
+		{"/releases/%C3%A9t%C3%A9/", true},
+		{"//", false},
+		{"//releases/", false},
+		{"/releases//", false},
+		{"/releases//app.tar.gz", false},
+		{"/releases%2Fapp.tar.gz", false},
+		{"/releases%2fapp.tar.gz", false},
+		{"/releases/..%2Fsecret", false},
+		{"/releases/%252F", true},
+		{"/./releases/", false},
+		{"/releases/./app.tar.gz", false},
+		{"/releases/.", false},
+		{"/releases/../secret", false},
+		{"/releases/..", false},
+		{"/releases/../", false},
+		{"/releases/%2e%2e/secret", false},
+		{"/releases/%2E%2E/", false},
+		{"/releases/.%2e/secret", false},
+		{"/releases/%2e/app.tar.gz", false},
+		{"/releases/app%00.tar.gz", false},
+		{"/releases/app%0A.tar.gz", false},
+		{"/releases/app%7F.tar.gz", false},
+	}
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			u, err := url.ParseRequestURI(test.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkPath(u)
+			if test.ok && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if !test.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			// Accepted paths stay within the prefix of their mount point.
+			mountPoint, name := resolvePath(context.Background(), u.Path)
+			if mountPoint == nil {
+				t.Fatal("no mount point")
+			}
+			if !strings.HasPrefix(name, mountPoint.Prefix) {
+				t.Errorf("object name %q is outside of prefix %q", name, mountPoint.Prefix)
+			}
+			for _, segment := range strings.Split(name, "/") {
+				if segment == "." || segment == ".." {
+					t.Errorf("object name %q has dot segments", name)
+				}
+			}
+		})
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	setMountPoints(t,
+		[3]string{"/", "public", ""},
+		[3]string{"/releases/", "public", "releases/"},
+		[3]string{"/internal/", "private", "docs/internal"},
+		[3]string{"/internal/archive/", "cold", ""},
+	)
+
+	var tests = []struct {
+		path, bucket, name string
+	}{
+		{"/", "public", ""},
+		{"/README.md", "public", "README.md"},
+		{"/releases", "public", "releases"},
+		{"/releases/", "public", "releases/"},
+		{"/releases/1.2.3/app.tar.gz", "public", "releases/1.2.3/app.tar.gz"},
+		{"/internal/", "private", "docs/internal/"},
+		{"/internal/notes/README.md", "private", "docs/internal/notes/README.md"},
+		{"/internal/archived", "private", "docs/internal/archived"},
+		{"/internal/archive", "private", "docs/internal/archive"},
+		{"/internal/archive/", "cold", ""},
+		{"/internal/archive/2020/", "cold", "2020/"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			mountPoint, name := resolvePath(context.Background(), test.path)
+			if mountPoint == nil {
+				t.Fatal("no mount point")
+			}
+			if mountPoint.Bucket != test.bucket || name != test.name {
+				t.Errorf("resolved to %s/%s, want %s/%s", mountPoint.Bucket, name, test.bucket, test.name)
+			}
+			if objectName := mountPoint.ObjectName(test.path); objectName != name {
+				t.Errorf("ObjectName is %q, resolvePath %q", objectName, name)
+			}
+		})
+	}
+}
+
+func TestResolvePathWithoutMountPoint(t *testing.T) {
+	setMountPoints(t, [3]string{"/releases/", "public", "releases/"})
+
+	for _, path := range []string{"/", "/releases", "/other/releases/"} {
+		if mountPoint, name := resolvePath(context.Background(), path); mountPoint != nil {
+			t.Errorf("%s resolved to %s/%s", path, mountPoint.Bucket, name)
+		} else if name != "" {
+			t.Errorf("%s resolved to object %q without a mount point", path, name)
+		}
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	var tests = []struct {
+		mount, prefix, path, name string
+	}{
+		{"/", "", "/", ""},
+		{"/", "", "/a/b.txt", "a/b.txt"},
+		{"/", "root", "/a/", "root/a/"},
+		{"/m/", "", "/m/", ""},
+		{"/m/", "", "/m/README.md", "README.md"},
+		{"/m/", "p/", "/m/", "p/"},
+		{"/m/", "p/", "/m/d/README.md", "p/d/README.md"},
+		{"/m/", "p/q/", "/m/m/", "p/q/m/"},
+	}
+	for _, test := range tests {
+		mountPoint, err := newMountPoint(test.mount, "bucket", test.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := mountPoint.ObjectName(test.path); name != test.name {
+			t.Errorf("ObjectName(%q) below %s:%s is %q, want %q", test.path, test.mount, test.prefix, name, test.name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// primeWorkers is how many objects are fetched through the CDN at once.
+const primeWorkers = 8
+
+var primeClient = &http.Client{Timeout: 10 * time.Minute}
+
+type primeResponse struct {
+	Primed int            `json:"primed"`
+	Failed []primeFailure `json:"failed,omitempty"`
+}
+
+type primeFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// checkPrimeURL validates a URL template of -prime-url, which must point to
+// the object with {path} or {url}.
+func checkPrimeURL(template string) error {
+	if template == "" {
+		return nil
+	}
+	if !strings.Contains(template, "{path}") && !strings.Contains(template, "{url}") {
+		return fmt.Errorf("prime URL %q has neither {path} nor {url}", template)
+	}
+	u, err := url.Parse(primeURL(template, "/", "/"))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("prime URL %q is not http or https", template)
+	}
+	return nil
+}
+
+// primeURL fills a URL template with the escaped path of an object, or its
+// absolute URL escaped as a query parameter.
+func primeURL(template, path, absolute string) string {
+	return strings.NewReplacer("{path}", escapePath(path), "{url}", url.QueryEscape(absolute)).Replace(template)
+}
+
+// prime fetches the object at the request path, or all the objects below a
+// directory, through the prime URL of the mount point, so that a CDN in front
+// of gcs-index has them before they are announced.
+func prime(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "prime")
+	defer span.End()
+
+	var mountPoint = findMountPoint(ctx, r.URL.Path)
+	if mountPoint == nil || mountPoint.PrimeURL == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var links = linksFor(r)
+	var paths = make(chan string)
+	var mu sync.Mutex
+	var response primeResponse
+	var wg sync.WaitGroup
+	for range primeWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				err := primeObject(ctx, primeURL(mountPoint.PrimeURL, path, links.Absolute(path)))
+				mu.Lock()
+				if err != nil {
+					slog.Warn("failed to prime object", "path", path, "err", err)
+					response.Failed = append(response.Failed, primeFailure{Path: path, Error: err.Error()})
+				} else {
+					response.Primed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+	if strings.HasSuffix(r.URL.Path, "/") {
+		_, _, err = walkStorage(ctx, mountPoint, r.URL.Path, ListOptions{Recursive: true}, 0, func(item Item) {
+			if !item.Dir && !strings.HasSuffix(item.Name, "/") {
+				paths <- r.URL.Path + item.Name
+			}
+		})
+	} else {
+		paths <- r.URL.Path
+	}
+	close(paths)
+	wg.Wait()
+	if err != nil {
+		span.RecordError(err)
+		slog.Error("priming aborted", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	audit(r, "prime", "path", r.URL.Path, "primed", response.Primed, "failed", len(response.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(response.Failed) > 0 {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// primeObject fetches a URL in full, asking caches along the way to revalidate
+// what they may already have.
+func primeObject(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	res, err := primeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotModified {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	return nil
+}
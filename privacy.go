@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Privacy levels of mount points.
+const (
+	privacyPublic   = "public"   // Listed and indexed.
+	privacyUnlisted = "unlisted" // Objects are served, directories aren't listed.
+	privacyPrivate  = "private"  // Authentication is required.
+)
+
+// checkPrivacy validates the privacy level of a mount point against its other
+// options.
+func checkPrivacy(mountPoint *MountPoint) error {
+	switch mountPoint.Privacy {
+	case privacyPublic:
+	case privacyUnlisted:
+		if !mountPoint.NoListings {
+			return errors.New("unlisted mount points can't have listings")
+		}
+	case privacyPrivate:
+		if mountPoint.BasicAuth == nil && mountPoint.OIDCAuth == nil && *iapAudience == "" {
+			return errors.New("private mount points require authentication")
+		}
+	default:
+		return fmt.Errorf("privacy must be %s, %s or %s, not %q", privacyPublic, privacyUnlisted, privacyPrivate, mountPoint.Privacy)
+	}
+	return nil
+}
+
+// setNoIndex keeps search engines from indexing the responses of mount points
+// that aren't public, even when crawlers find links to them elsewhere.
+func setNoIndex(h http.Header, mountPoint *MountPoint) {
+	if mountPoint != nil && mountPoint.Privacy != privacyPublic {
+		h.Set("X-Robots-Tag", "noindex, nofollow")
+	}
+}
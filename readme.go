@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"time"
 
@@ -15,71 +15,56 @@ import (
 
 var md = goldmark.New(goldmark.WithExtensions(extension.GFM))
 
-const rmCacheMaxSize = 16 * 1024 * 1024 // 16 MB
+const rmCacheMaxSize = 16 * 1024 * 1024      // 16 MB
+const rmCacheMaxObjectSize = 1 * 1024 * 1024 // Larger readmes are rendered but not cached.
 
-var rmCacheSize = 0
-var rmCache = make(map[string]readmeCacheEntry)
-var rmKeys = make([]string, 0)
+// readmeCache holds readmes by object name. Outdated generations are still
+// served while the new one is fetched in the background.
+var readmeCache = newMemoryCache("readme", rmCacheMaxSize, rmCacheMaxObjectSize, func(readme cachedReadme) int {
+	return len(readme.markdown)
+})
 
-type readmeCacheEntry struct {
-	markdown  []byte
-	timestamp time.Time
+type cachedReadme struct {
+	markdown   []byte
+	generation int64
 }
 
-func renderReadme(ctx context.Context, w *bufio.Writer, attrs *storage.ObjectAttrs) {
-	if markdown, err := fetchReadme(ctx, attrs); err != nil {
+func renderReadme(ctx context.Context, w io.Writer, mountPoint *MountPoint, attrs *storage.ObjectAttrs) {
+	if markdown, err := fetchReadme(ctx, mountPoint, attrs); err != nil {
 		slog.Error("failed to fetch readme", "err", err)
 	} else if err := md.Convert(markdown, w); err != nil {
 		slog.Error("failed to render readme", "err", err)
 	}
 }
 
-func fetchReadme(ctx context.Context, attrs *storage.ObjectAttrs) ([]byte, error) {
-	var key = cacheKey(attrs)
-	if entry, ok := rmCache[key]; ok && !entry.timestamp.After(attrs.Updated) {
-		return entry.markdown, nil
-	}
+func fetchReadme(ctx context.Context, mountPoint *MountPoint, attrs *storage.ObjectAttrs) ([]byte, error) {
+	readme, hit, err := readmeCache.Get(ctx, attrs.Bucket+"/"+attrs.Name,
+		func(readme cachedReadme, _ time.Time) bool {
+			return readme.generation >= attrs.Generation
+		},
+		func(ctx context.Context) (cachedReadme, error) {
+			return readReadme(ctx, attrs.Bucket, attrs.Name)
+		})
+	countReadmeCache(mountPoint, hit)
+	return readme.markdown, err
+}
+
+func readReadme(ctx context.Context, bucket, name string) (cachedReadme, error) {
+	slog.Info("fetching readme", "bucket", bucket, "name", name)
 
-	slog.Info("fetching readme", "bucket", attrs.Bucket, "name", attrs.Name)
+	ctx, span := tracer.Start(ctx, "storage.ReadReadme")
+	defer span.End()
 
-	obj := client.Bucket(attrs.Bucket).Object(attrs.Name)
-	reader, err := obj.NewReader(ctx)
+	reader, err := client.Bucket(bucket).Object(name).NewReader(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("newReader: %w", err)
+		return cachedReadme{}, fmt.Errorf("newReader: %w", err)
 	}
 	defer reader.Close()
 
 	var readme bytes.Buffer
 	if _, err = readme.ReadFrom(reader); err != nil {
-		return nil, fmt.Errorf("readFrom: %w", err)
-	}
-
-	var markdown = readme.Bytes()
-
-	// Insert in cache
-	var _, wasInCache = rmCache[key]
-	rmCache[key] = readmeCacheEntry{
-		markdown:  markdown,
-		timestamp: attrs.Updated,
+		return cachedReadme{}, fmt.Errorf("readFrom: %w", err)
 	}
 
-	// Purge cache
-	if !wasInCache {
-		rmCacheSize += len(markdown)
-		rmKeys = append(rmKeys, key)
-
-		slog.Info("purging readme cache", "size", rmCacheSize)
-		for rmCacheSize > rmCacheMaxSize && len(rmKeys) > 0 {
-			var key = rmKeys[0]
-			rmCacheSize -= len(rmCache[key].markdown)
-			delete(rmCache, key)
-			rmKeys = rmKeys[1:]
-		}
-	}
-
-	return markdown, nil
-}
-
-func cacheKey(attrs *storage.ObjectAttrs) string {
-	return attrs.Bucket + "/" + attrs.Name
+	return cachedReadme{readme.Bytes(), reader.Attrs.Generation}, nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"os"
+	"runtime/debug"
+)
+
+// readyEvent is written as a single JSON line on stdout once the server
+// accepts requests, so that supervisors don't have to parse logs.
+type readyEvent struct {
+	Event       string          `json:"event"`
+	Version     string          `json:"version"`
+	Listeners   []readyListener `json:"listeners"`
+	MountPoints int             `json:"mountPoints"`
+}
+
+type readyListener struct {
+	Name    string `json:"name"` // http or metrics.
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+}
+
+// announceReady writes the ready event, metricsListener being nil without
+// -metrics-addr.
+func announceReady(listener, metricsListener net.Listener) {
+	var event = readyEvent{Event: "ready", Version: buildVersion(), MountPoints: len(getMountPoints(context.Background()))}
+	event.Listeners = append(event.Listeners, readyListener{"http", listener.Addr().Network(), listener.Addr().String()})
+	if metricsListener != nil {
+		event.Listeners = append(event.Listeners, readyListener{"metrics", metricsListener.Addr().Network(), metricsListener.Addr().String()})
+	}
+	json.NewEncoder(os.Stdout).Encode(event)
+}
+
+// buildVersion returns the module version of the binary, or the VCS revision
+// it was built from.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if revision == "" {
+		return "devel"
+	}
+	return revision + modified
+}
+
+// logStartup logs the progress of the startup, unless -quiet leaves it to the
+// ready event.
+func logStartup(msg string, args ...any) {
+	if !*quiet {
+		slog.Info(msg, args...)
+	}
+}
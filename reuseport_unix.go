@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenTCP listens on a TCP address, with SO_REUSEPORT if -reuseport is set so
+// that another instance can bind the same port, e.g. while rolling out a new
+// version next to the old one. The kernel then balances new connections
+// between them.
+func listenTCP(addr string) (net.Listener, error) {
+	var config net.ListenConfig
+	if *reusePort {
+		config.Control = func(_, _ string, conn syscall.RawConn) error {
+			var err error
+			if controlErr := conn.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); controlErr != nil {
+				return controlErr
+			}
+			return err
+		}
+	}
+	return config.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// listenTCP listens on a TCP address. Windows has no SO_REUSEPORT, and
+// SO_REUSEADDR would let another process steal the port, so -reuseport fails.
+func listenTCP(addr string) (net.Listener, error) {
+	if *reusePort {
+		return nil, errors.New("-reuseport is not supported on Windows")
+	}
+	var config net.ListenConfig
+	return config.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// checkRobots validates -robots, which is either a policy or the path of a
+// file to serve as is.
+func checkRobots() error {
+	if *robots == "" || *robots == "allow" || *robots == "disallow" {
+		return nil
+	}
+	_, err := os.Stat(*robots)
+	return err
+}
+
+func checkRobotsPolicy(policy string) error {
+	if policy != "allow" && policy != "disallow" {
+		return fmt.Errorf("invalid robots policy %q", policy)
+	}
+	return nil
+}
+
+// defaultRobotsPolicy is the policy of mount points without their own, unless
+// -robots is a file.
+func defaultRobotsPolicy() string {
+	if checkRobotsPolicy(*robots) == nil {
+		return *robots
+	}
+	return ""
+}
+
+// robotsEnabled tells whether /robots.txt is served rather than looked up in
+// a bucket.
+func robotsEnabled(ctx context.Context) bool {
+	if *robots != "" {
+		return true
+	}
+	for _, mountPoint := range getMountPoints(ctx) {
+		if mountPoint.Robots != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRobots serves the -robots file, or rules generated from the robots
+// policies of the mount points. Crawlers are kept off query strings, which
+// only give other views of the same listings.
+func handleRobots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", defaultCacheControl)
+
+	if *robots != "" && *robots != "allow" && *robots != "disallow" {
+		content, err := os.ReadFile(*robots)
+		if err != nil {
+			slog.Error("failed to read robots file", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(content)
+		return
+	}
+
+	var rules = []string{"User-agent: *"}
+	var allowed = *robots != "disallow"
+	if !allowed {
+		rules = append(rules, "Disallow: /")
+	}
+	for _, mountPoint := range getMountPoints(r.Context()) {
+		switch {
+		case mountPoint.Robots == "allow" && *robots == "disallow":
+			rules = append(rules, "Allow: "+mountPoint.Path)
+			allowed = true
+		case mountPoint.Robots == "disallow" && *robots != "disallow":
+			rules = append(rules, "Disallow: "+mountPoint.Path)
+		}
+	}
+	if allowed {
+		rules = append(rules, "Disallow: /*?")
+		if *sitemapTTL > 0 {
+			rules = append(rules, "", "Sitemap: "+linksFor(r).Absolute("/sitemap.xml"))
+		}
+	}
+	w.Write([]byte(strings.Join(rules, "\n") + "\n"))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+const maxS3Keys = 1000
+
+// s3ListBucketResult mimics the response of S3's ListObjectsV2, so that S3
+// clients can browse mount points as if they were buckets.
+type s3ListBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Namespace             string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// isS3Listing tells whether the request is an S3 ListObjectsV2 call.
+func isS3Listing(r *http.Request) bool {
+	return r.URL.Query().Has("list-type")
+}
+
+// s3ListingPath returns the directory an S3 listing is about: the bucket path,
+// which S3 clients send without a trailing slash, followed by the directories
+// of the prefix. Authentication applies to that path.
+func s3ListingPath(r *http.Request) string {
+	var prefix = r.URL.Query().Get("prefix")
+	return strings.TrimSuffix(r.URL.Path, "/") + "/" + prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// handleS3List lists a mount point like S3's ListObjectsV2. Keys are relative
+// to the mount point; nested mount points are not included.
+func handleS3List(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleS3List")
+	defer span.End()
+
+	var mountPoint = findMountPoint(ctx, r.URL.Path)
+	if mountPoint == nil || mountPoint.NoListings {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	var query = r.URL.Query()
+	if query.Get("list-type") != "2" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Only list-type=2 is supported")
+		return
+	}
+
+	var prefix = query.Get("prefix")
+	var result = s3ListBucketResult{
+		Namespace:         s3Namespace,
+		Name:              strings.Trim(mountPoint.Path, "/"),
+		Prefix:            strings.TrimPrefix(r.URL.Path, mountPoint.Path) + prefix[strings.LastIndex(prefix, "/")+1:],
+		Delimiter:         query.Get("delimiter"),
+		MaxKeys:           maxS3Keys,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+	}
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		result.MaxKeys = min(maxKeys, maxS3Keys)
+	}
+	if value := query.Get("encoding-type"); value != "" && value != "url" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "Invalid encoding-type")
+		return
+	}
+	if hasNoIndexMarker(ctx, mountPoint, mountPoint.Path+result.Prefix[:strings.LastIndex(result.Prefix, "/")+1]) {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+
+	var gcsQuery = &storage.Query{
+		Prefix:    mountPoint.Prefix + result.Prefix,
+		Delimiter: result.Delimiter,
+	}
+	if result.StartAfter > result.Prefix {
+		gcsQuery.StartOffset = mountPoint.Prefix + result.StartAfter
+	}
+	if result.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			return
+		}
+		gcsQuery.StartOffset = mountPoint.Prefix + string(token)
+	}
+
+	// Marked directories are fine as common prefixes, not their keys.
+	var marked []string
+	if result.Delimiter != "/" {
+		var err error
+		if marked, err = noIndexDirs(ctx, client.Bucket(mountPoint.Bucket), gcsQuery.Prefix); err != nil {
+			span.RecordError(err)
+			slog.Error("failed to look up noindex markers", "bucket", mountPoint.Bucket, "prefix", gcsQuery.Prefix, "err", err)
+			writeS3Error(w, http.StatusBadGateway, "InternalError", "Failed to list objects")
+			return
+		}
+	}
+	objects := client.Bucket(mountPoint.Bucket).Objects(ctx, gcsQuery)
+	for {
+		attrs, err := objects.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			span.RecordError(err)
+			slog.Error("failed to list objects", "bucket", mountPoint.Bucket, "prefix", gcsQuery.Prefix, "err", err)
+			writeS3Error(w, http.StatusBadGateway, "InternalError", "Failed to list objects")
+			return
+		}
+
+		var key = strings.TrimPrefix(attrs.Name+attrs.Prefix, mountPoint.Prefix)
+		if mountPoint.isStaged(attrs.Name+attrs.Prefix) || key == result.StartAfter || (attrs.Name != "" && inNoIndexDir(strings.TrimPrefix(attrs.Name, gcsQuery.Prefix), marked)) {
+			continue
+		}
+		if result.KeyCount >= result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(key))
+			break
+		}
+		result.KeyCount++
+
+		if attrs.Prefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{s3Key(query, key)})
+			continue
+		}
+		// S3 clients expect the MD5 as ETag, which composite objects lack.
+		var etag = attrs.Etag
+		if len(attrs.MD5) > 0 {
+			etag = fmt.Sprintf("%x", attrs.MD5)
+		}
+		result.Contents = append(result.Contents, s3Object{
+			Key:          s3Key(query, key),
+			LastModified: attrs.Updated.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         "\"" + etag + "\"",
+			Size:         attrs.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.Delimiter = url.QueryEscape(result.Delimiter)
+		result.StartAfter = url.QueryEscape(result.StartAfter)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Last-Modified", time.Now().Truncate(time.Minute).Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", mountPoint.CacheControl)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("failed to encode listing", "err", err)
+	}
+}
+
+// s3Key encodes keys if the client asked for it, as S3 clients do by default
+// to get keys that are not valid XML through.
+func s3Key(query url.Values, key string) string {
+	if query.Get("encoding-type") == "url" {
+		return url.QueryEscape(key)
+	}
+	return key
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errInfected = errors.New("content rejected by scanner")
+
+// Scanner checks content uploaded to a mount point before it is exposed.
+type Scanner interface {
+	// Scan reads the content and returns an error wrapping errInfected if it
+	// must be rejected.
+	Scan(ctx context.Context, path string, content io.Reader) error
+}
+
+// httpScanner posts content to a scanning service. 2xx responses mean clean
+// content, 403, 406 and 422 mean rejected content with the reason in the body.
+type httpScanner struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPScanner(url string, timeout time.Duration) (*httpScanner, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("scanner: invalid url %q", url)
+	}
+	return &httpScanner{url, &http.Client{Timeout: timeout}}, nil
+}
+
+func (s *httpScanner) Scan(ctx context.Context, path string, content io.Reader) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, content)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("X-Object-Path", path)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("scanner: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusForbidden, http.StatusNotAcceptable, http.StatusUnprocessableEntity:
+		reason, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%w: %s", errInfected, strings.TrimSpace(string(reason)))
+	default:
+		return fmt.Errorf("scanner: unexpected status %s", response.Status)
+	}
+}
+
+// sameScanner tells whether content from a mount point scanned by a was
+// already scanned the way b would.
+func sameScanner(a, b Scanner) bool {
+	x, ok := a.(*httpScanner)
+	y, ok2 := b.(*httpScanner)
+	return ok && ok2 && x.url == y.url
+}
+
+// startScan scans whatever is written to the returned writer, and delivers the
+// verdict once the writer is closed. The content is consumed entirely even if
+// the scanner gives up early, so that writes never block.
+func startScan(ctx context.Context, scanner Scanner, path string) (*io.PipeWriter, <-chan error) {
+	reader, writer := io.Pipe()
+	verdict := make(chan error, 1)
+	go func() {
+		err := scanner.Scan(ctx, path, reader)
+		io.Copy(io.Discard, reader)
+		verdict <- err
+	}()
+	return writer, verdict
+}
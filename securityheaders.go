@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Responses the security headers are set on, from -security-headers or a
+// per-mount apply-to.
+const (
+	securityHeadersListings = "listings" // HTML listings only.
+	securityHeadersAll      = "all"      // Objects too.
+	securityHeadersNone     = "none"
+)
+
+// defaultSecurityHeaders suit the HTML listings, which only have an inline
+// style sheet and scripts, and images in README files.
+var defaultSecurityHeaders = http.Header{
+	"Content-Security-Policy":    {"default-src 'none'; script-src " + scriptSource(themeScript) + " " + scriptSource(tableScript) + "; style-src 'unsafe-inline'; img-src 'self' https: data:; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"},
+	"Cross-Origin-Opener-Policy": {"same-origin"},
+	"Referrer-Policy":            {"strict-origin-when-cross-origin"},
+	"X-Content-Type-Options":     {"nosniff"},
+	"X-Frame-Options":            {"DENY"},
+}
+
+// scriptSource allows an inline script in a Content-Security-Policy.
+func scriptSource(script string) string {
+	var sum = sha256.Sum256([]byte(script))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+func checkSecurityScope(scope string) error {
+	if !slices.Contains([]string{securityHeadersListings, securityHeadersAll, securityHeadersNone}, scope) {
+		return fmt.Errorf("security headers must apply to %s, %s or %s, not %q", securityHeadersListings, securityHeadersAll, securityHeadersNone, scope)
+	}
+	return nil
+}
+
+// mergeSecurityHeaders returns the headers overridden by others, where empty
+// values remove headers.
+func mergeSecurityHeaders(headers http.Header, overrides map[string]string) http.Header {
+	var result = headers.Clone()
+	for name, value := range overrides {
+		if value == "" {
+			result.Del(name)
+		} else {
+			result.Set(name, value)
+		}
+	}
+	return result
+}
+
+// setSecurityHeaders sets the security headers of the mount point on an HTML
+// listing, or on an object if they apply to all responses.
+func setSecurityHeaders(h http.Header, mountPoint *MountPoint, object bool) {
+	var scope, headers = *securityHeaders, defaultSecurityHeaders
+	if mountPoint != nil {
+		scope, headers = mountPoint.SecurityScope, mountPoint.SecurityHeaders
+	}
+	if scope == securityHeadersNone || (object && scope != securityHeadersAll) {
+		return
+	}
+	for name, values := range headers {
+		h[name] = values
+	}
+}
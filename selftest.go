@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"cloud.google.com/go/storage"
+)
+
+const selftestCommand = "selftest"
+
+// mountPointArgs returns the mount points given on the command line, after
+// the selftest command and its argument if any.
+func mountPointArgs() []string {
+	if flag.Arg(0) == selftestCommand {
+		return flag.Args()[min(2, flag.NArg()):]
+	}
+	return flag.Args()
+}
+
+// selftest is a sequence of checks of what gcs-index serves from a directory,
+// each relying on the previous ones.
+type selftest struct {
+	path   string
+	output *tabwriter.Writer
+	failed bool
+}
+
+// runSelftest exercises listings, objects and README rendering in a directory
+// with the mount points and flags of the command line, in process, and reports
+// the outcome of each check on stdout. Authentication is bypassed.
+func runSelftest(path string) {
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	var t = &selftest{path: path, output: tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)}
+	fmt.Fprintln(t.output, "CHECK\tRESULT\tDETAIL")
+	t.run()
+	t.output.Flush()
+	if t.failed {
+		fatal(exitSelftest, "self test failed", fmt.Errorf("%s", path))
+	}
+}
+
+func (t *selftest) report(check, result, detail string, args ...any) {
+	fmt.Fprintf(t.output, "%s\t%s\t%s\n", check, result, fmt.Sprintf(detail, args...))
+	if result == "FAIL" {
+		t.failed = true
+	}
+}
+
+// request answers a GET request for the path with the handler.
+func (t *selftest) request(handler http.HandlerFunc, path string, header http.Header, discard bool) *httptest.ResponseRecorder {
+	var r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.URL.Path = path
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	var w = httptest.NewRecorder()
+	if discard {
+		w.Body = nil
+	}
+	handler(w, r)
+	return w
+}
+
+func (t *selftest) run() {
+	var mountPoint = findMountPoint(context.Background(), t.path)
+	if mountPoint == nil {
+		t.report("mount", "FAIL", "no mount point for %s", t.path)
+		return
+	}
+	t.report("mount", "PASS", "gs://%s/%s", mountPoint.Bucket, mountPoint.ObjectName(t.path))
+
+	var res = t.request(handleIndex, t.path, http.Header{"Accept": {jsonContentType}}, false)
+	var listing Listing
+	if res.Code != http.StatusOK {
+		t.report("listing", "FAIL", "status %d", res.Code)
+		return
+	} else if err := json.Unmarshal(res.Body.Bytes(), &listing); err != nil {
+		t.report("listing", "FAIL", "%v", err)
+		return
+	}
+	t.report("listing", "PASS", "%d entries", len(listing.Items))
+
+	res = t.request(handleIndex, t.path, http.Header{"Accept": {"text/html"}}, false)
+	if contentType := res.Header().Get("Content-Type"); res.Code != http.StatusOK || !strings.HasPrefix(contentType, "text/html") {
+		t.report("html listing", "FAIL", "status %d, %s", res.Code, contentType)
+	} else {
+		t.report("html listing", "PASS", "%d bytes", res.Body.Len())
+	}
+
+	t.checkObject(mountPoint, listing.Items)
+	t.checkReadme(mountPoint)
+}
+
+func (t *selftest) checkObject(mountPoint *MountPoint, items []Item) {
+	var item *Item
+	for i := range items {
+		if !items[i].Dir && items[i].Size > 0 {
+			item = &items[i]
+			break
+		}
+	}
+	if item == nil {
+		t.report("object", "SKIP", "no object in the directory")
+		return
+	}
+
+	var path = t.path + item.Name
+	var res = t.request(handleObject, path, nil, true)
+	switch {
+	case res.Code == http.StatusFound && mountPoint.RedirectSigned:
+		t.report("object", "PASS", "%s redirected to a signed URL", item.Name)
+		return
+	case res.Code != http.StatusOK:
+		t.report("object", "FAIL", "%s: status %d", item.Name, res.Code)
+		return
+	case res.Header().Get("Content-Length") != strconv.FormatInt(item.Size, 10):
+		t.report("object", "FAIL", "%s: %s bytes instead of %d", item.Name, res.Header().Get("Content-Length"), item.Size)
+		return
+	}
+	t.report("object", "PASS", "%s, %d bytes", item.Name, item.Size)
+
+	var etag, lastModified = res.Header().Get("ETag"), res.Header().Get("Last-Modified")
+	var etagRes = t.request(handleObject, path, http.Header{"If-None-Match": {etag}}, true)
+	var dateRes = t.request(handleObject, path, http.Header{"If-Modified-Since": {lastModified}}, true)
+	if etagRes.Code != http.StatusNotModified || dateRes.Code != http.StatusNotModified {
+		t.report("conditional", "FAIL", "status %d with If-None-Match, %d with If-Modified-Since", etagRes.Code, dateRes.Code)
+	} else {
+		t.report("conditional", "PASS", "304 with If-None-Match and If-Modified-Since")
+	}
+
+	res = t.request(handleObject, path, http.Header{"Range": {"bytes=0-0"}}, true)
+	switch contentRange := res.Header().Get("Content-Range"); {
+	case res.Code == http.StatusPartialContent && contentRange == fmt.Sprintf("bytes 0-0/%d", item.Size):
+		t.report("range", "PASS", "%s", contentRange)
+	case res.Code == http.StatusOK:
+		t.report("range", "SKIP", "ranges are only served from the disk cache")
+	default:
+		t.report("range", "FAIL", "status %d, %q", res.Code, contentRange)
+	}
+}
+
+func (t *selftest) checkReadme(mountPoint *MountPoint) {
+	if !mountPoint.Readme {
+		t.report("readme", "SKIP", "README rendering disabled")
+		return
+	}
+	var ctx = context.Background()
+	attrs, err := client.Bucket(mountPoint.Bucket).Object(mountPoint.ObjectName(t.path + "README.md")).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		t.report("readme", "SKIP", "no README.md in the directory")
+		return
+	} else if err != nil {
+		t.report("readme", "FAIL", "%v", err)
+		return
+	}
+	markdown, err := fetchReadme(ctx, mountPoint, attrs)
+	if err != nil {
+		t.report("readme", "FAIL", "%v", err)
+		return
+	}
+	var rendered bytes.Buffer
+	if err := md.Convert(markdown, &rendered); err != nil {
+		t.report("readme", "FAIL", "%v", err)
+		return
+	}
+	t.report("readme", "PASS", "%d bytes of markdown, %d of HTML", len(markdown), rendered.Len())
+}
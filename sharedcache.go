@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// maxSharedListingBytes bounds the listings kept by the leader of a shared
+// listing cache, oldest evicted first.
+const maxSharedListingBytes = 256 << 20
+
+// sharedListings is set with -listing-cache-socket, nil otherwise.
+var sharedListings *sharedListingCache
+
+// sharedListingCache lets the processes of a host share the listings they
+// fetch from GCS, so that each of them doesn't have to list the same hot
+// directories when its own listing cache is cold. One of them, the leader,
+// holds the listings and serves them to the others over a unix socket. The
+// leader is whoever holds the lock next to the socket; if it goes away, the
+// next process failing to reach it takes over.
+type sharedListingCache struct {
+	socket string
+	client *http.Client
+
+	mu       sync.Mutex
+	lock     *os.File // Held by the leader.
+	store    *sharedListingStore
+	lastVote time.Time
+}
+
+// sharedListing is a listing as exchanged between processes, with what isn't
+// in its JSON.
+type sharedListing struct {
+	Listing     *Listing             `json:"listing"`
+	Readme      *storage.ObjectAttrs `json:"readme,omitempty"`
+	Generations []int64              `json:"generations"`
+}
+
+func newSharedListingCache(socket string) *sharedListingCache {
+	var c = &sharedListingCache{socket: socket}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		slog.Error("failed to create shared listing cache directory", "socket", socket, "err", err)
+	}
+	c.client = &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	c.elect()
+	return c
+}
+
+// elect makes this process the leader if no other holds the lock.
+func (c *sharedListingCache) elect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store != nil || time.Since(c.lastVote) < time.Second {
+		return
+	}
+	c.lastVote = time.Now()
+
+	lock, err := os.OpenFile(c.socket+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		slog.Error("failed to open shared listing cache lock", "err", err)
+		return
+	}
+	if err := tryLock(lock); err != nil {
+		lock.Close()
+		return
+	}
+
+	// A leader that died left its socket behind. Only the user running the
+	// processes may put listings that all of them render.
+	os.Remove(c.socket)
+	var oldUmask = umask(0177)
+	listener, err := net.Listen("unix", c.socket)
+	if oldUmask >= 0 {
+		umask(oldUmask)
+	}
+	if err == nil {
+		err = os.Chmod(c.socket, 0600)
+	}
+	if err != nil {
+		slog.Error("failed to listen for shared listing cache", "socket", c.socket, "err", err)
+		if listener != nil {
+			listener.Close()
+		}
+		lock.Close()
+		return
+	}
+	c.lock, c.store = lock, newSharedListingStore()
+	slog.Info("leading shared listing cache", "socket", c.socket)
+	go http.Serve(listener, c.store)
+}
+
+// get returns the listing shared under the key, if any.
+func (c *sharedListingCache) get(ctx context.Context, key string) *Listing {
+	if c == nil {
+		return nil
+	}
+	var data []byte
+	if store := c.leader(); store != nil {
+		data = store.get(key)
+	} else {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://cache/listing?key="+url.QueryEscape(key), nil)
+		res, err := c.client.Do(req)
+		if err != nil {
+			slog.Warn("failed to reach shared listing cache", "err", err)
+			c.elect()
+			return nil
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil
+		}
+		if data, err = io.ReadAll(res.Body); err != nil {
+			return nil
+		}
+	}
+	if data == nil {
+		return nil
+	}
+
+	var shared sharedListing
+	if err := json.Unmarshal(data, &shared); err != nil || shared.Listing == nil || len(shared.Generations) != len(shared.Listing.Items) {
+		slog.Warn("invalid shared listing", "key", key, "err", err)
+		return nil
+	}
+	var listing = shared.Listing
+	for i := range listing.Items {
+		listing.Items[i].generation = shared.Generations[i]
+	}
+	listing.mountPoint = findMountPoint(ctx, listing.Path)
+	listing.readme = shared.Readme
+	listing.version = listingVersion(listing)
+	return listing
+}
+
+// put shares a listing under the key.
+func (c *sharedListingCache) put(key string, listing *Listing) {
+	if c == nil {
+		return
+	}
+	var shared = sharedListing{Listing: listing, Readme: listing.readme, Generations: make([]int64, len(listing.Items))}
+	for i, item := range listing.Items {
+		shared.Generations[i] = item.generation
+	}
+	data, err := json.Marshal(shared)
+	if err != nil {
+		slog.Error("failed to encode shared listing", "key", key, "err", err)
+		return
+	}
+
+	if store := c.leader(); store != nil {
+		store.put(key, data)
+		return
+	}
+	req, _ := http.NewRequest(http.MethodPut, "http://cache/listing?key="+url.QueryEscape(key), bytes.NewReader(data))
+	res, err := c.client.Do(req)
+	if err != nil {
+		slog.Warn("failed to reach shared listing cache", "err", err)
+		c.elect()
+		return
+	}
+	res.Body.Close()
+}
+
+// clear drops the shared listings if this process leads. Reloading another
+// process leaves them as they are, until they are refreshed or evicted; the
+// whole group must be reloaded for its listings to reflect new mount points.
+func (c *sharedListingCache) clear() {
+	if store := c.leader(); store != nil {
+		store.clear()
+	}
+}
+
+func (c *sharedListingCache) leader() *sharedListingStore {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.store
+}
+
+// sharedListingStore holds the encoded listings of the leader.
+type sharedListingStore struct {
+	mu      sync.Mutex
+	total   int
+	entries map[string]sharedListingEntry
+}
+
+type sharedListingEntry struct {
+	data   []byte
+	stored time.Time
+}
+
+func newSharedListingStore() *sharedListingStore {
+	return &sharedListingStore{entries: make(map[string]sharedListingEntry)}
+}
+
+func (s *sharedListingStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key = r.URL.Query().Get("key")
+	switch r.Method {
+	case http.MethodGet:
+		if data := s.get(key); data != nil {
+			w.Write(data)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodPut:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSharedListingBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.put(key, data)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *sharedListingStore) get(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key].data
+}
+
+func (s *sharedListingStore) put(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total += len(data) - len(s.entries[key].data)
+	s.entries[key] = sharedListingEntry{data, time.Now()}
+	for s.total > maxSharedListingBytes {
+		var oldestKey string
+		var oldest sharedListingEntry
+		for key, entry := range s.entries {
+			if oldest.data == nil || entry.stored.Before(oldest.stored) {
+				oldestKey, oldest = key, entry
+			}
+		}
+		s.total -= len(oldest.data)
+		delete(s.entries, oldestKey)
+	}
+}
+
+func (s *sharedListingStore) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.entries)
+	s.total = 0
+}
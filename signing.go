@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// signingKey signs listings other than HTML ones, nil unless -signing-key is
+// set.
+var signingKey ed25519.PrivateKey
+
+// loadSigningKey reads a PKCS #8 Ed25519 private key, as generated with
+// openssl genpkey -algorithm ed25519.
+func loadSigningKey(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return fmt.Errorf("%s: no PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var ok bool
+	if signingKey, ok = key.(ed25519.PrivateKey); !ok {
+		return fmt.Errorf("%s: %T is not an Ed25519 key", path, key)
+	}
+	return nil
+}
+
+// signingPublicKey returns the public key of the signatures, in PEM.
+func signingPublicKey() string {
+	der, err := x509.MarshalPKIXPublicKey(signingKey.Public())
+	if err != nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signListing sets the X-Signature header of a rendered listing, or answers
+// ?signature with the detached signature alone. HTML listings, whose relative
+// times change by the minute, aren't signed. It tells whether the listing is
+// still to be written.
+func signListing(w http.ResponseWriter, r *http.Request, format ListingFormat, body *bytes.Buffer) bool {
+	var detached = r.URL.Query().Has("signature")
+	if signingKey == nil || format.ContentType == htmlFormat.ContentType {
+		if detached {
+			w.WriteHeader(http.StatusNotFound)
+			return false
+		}
+		return true
+	}
+
+	var signature = ed25519.Sign(signingKey, body.Bytes())
+	if !detached {
+		w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(signature))
+		return true
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Del("Content-Disposition")
+	w.Write(signature)
+	return false
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// Sitemaps hold at most 50000 URLs, larger ones are split into shards listed
+// by a sitemap index. maxSitemapURLs bounds the memory used.
+const (
+	maxSitemapShardURLs = 50000
+	maxSitemapURLs      = 20 * maxSitemapShardURLs
+)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName   xml.Name     `xml:"urlset"`
+	Namespace string       `xml:"xmlns,attr"`
+	URLs      []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName   xml.Name     `xml:"sitemapindex"`
+	Namespace string       `xml:"xmlns,attr"`
+	Sitemaps  []sitemapURL `xml:"sitemap"`
+}
+
+// sitemapCache holds the URLs of the sitemap by base URL, rebuilt in the
+// background once older than -sitemap-ttl.
+var sitemapCache = newMemoryCache("sitemap", 4*maxSitemapURLs, maxSitemapURLs, func(urls []sitemapURL) int {
+	return len(urls) + 1
+})
+
+// sitemapShard returns the shard number of a sitemap path: 0 for the sitemap
+// itself, -1 for other paths.
+func sitemapShard(path string) int {
+	if path == "/sitemap.xml" {
+		return 0
+	}
+	if value, ok := strings.CutPrefix(path, "/sitemap-"); ok {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, ".xml")); err == nil && n > 0 && strings.HasSuffix(value, ".xml") {
+			return n
+		}
+	}
+	return -1
+}
+
+// handleSitemap serves the sitemap of public mount points: the mount paths and
+// all their objects. It is a sitemap index when there are too many URLs for a
+// single sitemap.
+func handleSitemap(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleSitemap")
+	defer span.End()
+
+	// Links are derived from the request, which the background refresh outlives.
+	var base = &http.Request{Host: r.Host, Header: r.Header.Clone(), TLS: r.TLS, URL: &url.URL{Path: "/"}}
+	var links = linksFor(base)
+	urls, _, err := sitemapCache.Get(ctx, links.Absolute("/"),
+		func(_ []sitemapURL, fetched time.Time) bool {
+			return time.Since(fetched) < *sitemapTTL
+		},
+		func(ctx context.Context) ([]sitemapURL, error) {
+			return buildSitemap(ctx, base)
+		})
+	if err != nil {
+		slog.Info("sitemap aborted", "err", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var shards = (len(urls) + maxSitemapShardURLs - 1) / maxSitemapShardURLs
+	var shard = sitemapShard(r.URL.Path)
+	var document any
+	switch {
+	case shard == 0 && shards <= 1:
+		document = sitemapURLSet{Namespace: sitemapNamespace, URLs: urls}
+	case shard == 0:
+		var index = sitemapIndex{Namespace: sitemapNamespace}
+		for i := 1; i <= shards; i++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: links.Absolute(fmt.Sprintf("/sitemap-%d.xml", i))})
+		}
+		document = index
+	case shard <= shards && shards > 1:
+		var start = (shard - 1) * maxSitemapShardURLs
+		document = sitemapURLSet{Namespace: sitemapNamespace, URLs: urls[start:min(start+maxSitemapShardURLs, len(urls))]}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Cache-Control", defaultCacheControl)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(document); err != nil {
+		slog.Error("failed to encode sitemap", "err", err)
+	}
+}
+
+// buildSitemap lists the objects of public mount points without
+// authentication.
+func buildSitemap(ctx context.Context, base *http.Request) ([]sitemapURL, error) {
+	var urls []sitemapURL
+	for _, mountPoint := range getMountPoints(ctx) {
+		if mountPoint.Privacy != privacyPublic || mountPoint.BasicAuth != nil || mountPoint.OIDCAuth != nil {
+			continue
+		}
+		if len(urls) >= maxSitemapURLs-1 {
+			slog.Warn("sitemap truncated", "urls", len(urls))
+			break
+		}
+		var request = *base
+		request.URL = &url.URL{Path: mountPoint.Path}
+		var links = linksFor(&request)
+		urls = append(urls, sitemapURL{Loc: links.Absolute(mountPoint.Path)})
+		_, _, err := walkStorage(ctx, &mountPoint, mountPoint.Path, ListOptions{Recursive: true, NoYanked: true}, maxSitemapURLs-len(urls), func(item Item) {
+			urls = append(urls, sitemapURL{
+				Loc:     links.Absolute(mountPoint.Path + item.Name),
+				LastMod: item.Updated.UTC().Format(time.RFC3339),
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+//go:embed page.html
+var defaultPage []byte
+
+//go:embed skins.html
+var skinTemplates string
+
+// tableScript sorts and filters the entries of the table skin in the browser.
+//
+//go:embed table.js
+var tableScript string
+
+// skins render the entries of HTML listings, the rest of the page being the
+// same for all of them: each is page.html, or the -template file, with its own
+// template of skins.html for the entries.
+var skins = map[string]*template.Template{
+	"table":   template.Must(skinTemplate("table", "page.html", defaultPage)),
+	"classic": template.Must(skinTemplate("classic", "page.html", defaultPage)),
+	"cards":   template.Must(skinTemplate("cards", "page.html", defaultPage)),
+}
+
+var templateFuncs = template.FuncMap{
+	"ago":    humanize.Time,
+	"comma":  humanize.Comma,
+	"ibytes": func(size int64) string { return humanize.IBytes(uint64(size)) },
+	"pad": func(name string, width int) string {
+		return strings.Repeat(" ", max(1, width-len([]rune(name))))
+	},
+}
+
+// skinTemplate parses a page along with skins.html, which the page may
+// override, and makes its "entries" template the one of the skin.
+func skinTemplate(skin, name string, page []byte) (*template.Template, error) {
+	var t = template.New(name).Funcs(templateFuncs)
+	if _, err := t.Parse(skinTemplates); err != nil {
+		return nil, err
+	}
+	if _, err := t.New("entries").Parse(`{{template "` + skin + `" .}}`); err != nil {
+		return nil, err
+	}
+	return t.Parse(string(page))
+}
+
+// pageTemplates parses a page replacing page.html for every skin, and renders
+// it once with an empty listing to catch references to missing fields.
+func pageTemplates(name string, page []byte) (map[string]*template.Template, error) {
+	var templates = make(map[string]*template.Template, len(skins))
+	for skin := range skins {
+		t, err := skinTemplate(skin, name, page)
+		if err != nil {
+			return nil, err
+		}
+		var empty = htmlPage{Listing: &Listing{Path: "/"}, MountPoint: &MountPoint{Path: "/"}, Messages: catalog["en"]}
+		if err := t.Execute(io.Discard, empty); err != nil {
+			return nil, err
+		}
+		templates[skin] = t
+	}
+	return templates, nil
+}
+
+// loadTemplate replaces page.html with a template file.
+func loadTemplate(path string) error {
+	page, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	templates, err := pageTemplates(filepath.Base(path), page)
+	if err != nil {
+		return err
+	}
+	skins = templates
+	return nil
+}
+
+// htmlPage is what the templates render: the listing along with its links and
+// messages.
+type htmlPage struct {
+	*Listing
+	MountPoint    *MountPoint // Nil for directories holding only mount points.
+	Messages      messages
+	Search        bool // Not for directories holding only mount points.
+	SortLinks     []htmlLink
+	DiskUsageLink string
+	VersionsLink  string
+	ContentTypes  bool // Objects have a column of content types.
+	TypesLink     string
+	Lifecycle     bool // Objects have columns of storage classes and custom times.
+	LifecycleLink string
+	ExpandLink    string
+	FirstLink     string
+	Entries       []htmlEntry
+	PerPage       int
+	Breadcrumbs   []htmlLink    // From the root to the directory, whose own Href is empty.
+	Readme        template.HTML // Rendered by goldmark, which leaves raw HTML out.
+	Header        template.HTML // From the .header.html of the directory.
+	ThemeScript   template.JS   // Toggles dark mode, allowed by the default Content-Security-Policy.
+	TableScript   template.JS   // Sorts and filters the table skin, allowed likewise.
+	StylesheetURL string        // From -css-url.
+	ExtraCSS      template.CSS  // From -extra-css.
+	Footer        template.HTML // From the .footer.html of the directory.
+	Indexed       bool          // The listing comes from the cache.
+}
+
+type htmlLink struct {
+	Label string
+	Href  string
+}
+
+// breadcrumbs links the directories of a path, relative to it so that they
+// still work behind a proxy serving gcs-index under a prefix.
+func breadcrumbs(path string) []htmlLink {
+	var names []string
+	if path != "/" {
+		names = strings.Split(strings.Trim(path, "/"), "/")
+	}
+	var links = []htmlLink{{Label: "/", Href: strings.Repeat("../", len(names))}}
+	for i, name := range names {
+		links = append(links, htmlLink{Label: name + "/", Href: strings.Repeat("../", len(names)-1-i)})
+	}
+	return links
+}
+
+type htmlEntry struct {
+	Item
+	Href  string
+	Kind  string // Shown as an icon, see itemKind.
+	Split bool   // First directory after objects, which the table skin puts apart.
+	Group string // Heading of the version starting with the entry, see groupByVersion.
+
+	Badges []string // See statusMetadataKey.
+}
+
+// skinFor picks the skin of the ?skin= parameter, or the one of the mount point.
+func skinFor(r *http.Request, mountPoint *MountPoint) string {
+	if skin := r.URL.Query().Get("skin"); skins[skin] != nil {
+		return skin
+	}
+	if mountPoint != nil {
+		return mountPoint.Skin
+	}
+	return *skin
+}
+
+// hiddenItem skips the favicon link on the root page, and the objects
+// customizing the page.
+func hiddenItem(listing *Listing, item Item) bool {
+	return (item.Name == "favicon.ico" && listing.Path == "/") || slices.Contains(dirPageObjects, item.Name)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// listenUnix listens on the unix socket given with -socket, replacing a stale
+// socket file left behind by a crash, then gives the socket the ownership and
+// mode given with -socket-owner, -socket-group and -socket-mode.
+func listenUnix(path string) (net.Listener, error) {
+	uid, gid, mode, err := socketPermissions()
+	if err != nil {
+		return nil, err
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	var oldUmask = -1
+	if *socketUmask >= 0 {
+		slog.Info("setting umask", "umask", *socketUmask)
+		oldUmask = umask(*socketUmask)
+	}
+	listener, err := net.Listen("unix", path)
+	if oldUmask >= 0 {
+		umask(oldUmask)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// socketPermissions resolves -socket-owner, -socket-group and -socket-mode,
+// with -1 and 0 for those left unset.
+func socketPermissions() (uid, gid int, mode fs.FileMode, err error) {
+	if err := checkSocketPermissionsSupport(); err != nil {
+		return 0, 0, 0, err
+	}
+	uid, gid = -1, -1
+	if *socketOwner != "" {
+		var lookup = user.Lookup
+		if _, err := strconv.Atoi(*socketOwner); err == nil {
+			lookup = user.LookupId
+		}
+		owner, err := lookup(*socketOwner)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("socket owner: %w", err)
+		}
+		uid, _ = strconv.Atoi(owner.Uid)
+	}
+	if *socketGroup != "" {
+		var lookup = user.LookupGroup
+		if _, err := strconv.Atoi(*socketGroup); err == nil {
+			lookup = user.LookupGroupId
+		}
+		group, err := lookup(*socketGroup)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("socket group: %w", err)
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+	if *socketMode != "" {
+		bits, err := strconv.ParseUint(*socketMode, 8, 32)
+		if err != nil || bits == 0 || bits > 0777 {
+			return 0, 0, 0, fmt.Errorf("invalid socket mode %q", *socketMode)
+		}
+		mode = fs.FileMode(bits)
+	}
+	return uid, gid, mode, nil
+}
+
+// removeStaleSocket removes a socket file nobody listens on anymore. Anything
+// else in the way, including a live socket, is left for net.Listen to fail on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode().Type() != fs.ModeSocket {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	if !isConnRefused(err) {
+		return nil
+	}
+	slog.Warn("removing stale socket", "socket", path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func checkSocketPermissionsSupport() error {
+	return nil
+}
+
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
+
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// checkSocketPermissionsSupport rejects the socket permission flags, which
+// have no equivalent for unix sockets on Windows, where they inherit the ACL of
+// their directory.
+func checkSocketPermissionsSupport() error {
+	if *socketUmask >= 0 || *socketOwner != "" || *socketGroup != "" || *socketMode != "" {
+		return errors.New("socket permissions are not supported on Windows")
+	}
+	return nil
+}
+
+func umask(int) int {
+	return -1
+}
+
+func isConnRefused(err error) bool {
+	return errors.Is(err, windows.WSAECONNREFUSED)
+}
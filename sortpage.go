@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// maxSortedEntries caps the directories listed whole for sorted paging.
+const maxSortedEntries = 100000
+
+// sortedPaging tells whether pages must be cut after sorting the whole
+// directory. GCS lists names in lexicographic order, so its own pages only
+// fit ascending name sorts in lexicographic order.
+func sortedPaging(query url.Values, reordered bool) bool {
+	if *maxEntries <= 0 {
+		return false
+	}
+	var sortBy = query.Get("sort")
+	return sortBy == "size" || sortBy == "time" || query.Get("order") == "desc" || reordered
+}
+
+// versionSchemeFor returns the version scheme of the mount point, or an empty
+// string without version sort.
+func versionSchemeFor(mountPoint *MountPoint) string {
+	switch {
+	case mountPoint == nil && *versionSort:
+		return *versionScheme
+	case mountPoint == nil || !mountPoint.VersionSort:
+		return ""
+	default:
+		return mountPoint.VersionScheme
+	}
+}
+
+// sortCursor is the last entry of a sorted page, from which the next page
+// starts. Pages start right after it in sort order even if it was deleted in
+// the meantime, so that entries are neither repeated nor skipped.
+type sortCursor struct {
+	Name    string `json:"n"`
+	Dir     bool   `json:"d,omitempty"`
+	Size    int64  `json:"s,omitempty"`
+	Updated int64  `json:"t,omitempty"`
+}
+
+func encodeSortCursor(item Item) string {
+	var cursor = sortCursor{Name: item.Name, Dir: item.Dir, Size: item.Size}
+	if item.Updated != nil {
+		cursor.Updated = item.Updated.UnixNano()
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSortCursor(value string) (*Item, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor sortCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	var item = &Item{Name: cursor.Name, Dir: cursor.Dir, Size: cursor.Size}
+	if !cursor.Dir {
+		var updated = time.Unix(0, cursor.Updated)
+		item.Updated = &updated
+	}
+	return item, nil
+}
+
+// pageSorted cuts the page starting after the cursor out of a whole sorted
+// directory.
+func pageSorted(listing *Listing, after *Item, compareNames func(a, b string) int) {
+	if listing.Truncated {
+		slog.Warn("directory too large for sorted paging", "path", listing.Path, "entries", len(listing.Items))
+	}
+
+	var compare = itemComparator(cmp.Or(listing.Sort, "name"), listing.Order == "desc", compareNames)
+	var start int
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(listing.Items, *after, compare)
+		if start < len(listing.Items) && compare(listing.Items[start], *after) == 0 {
+			start++
+		}
+	}
+
+	var total = len(listing.Items)
+	var end = min(start+*maxEntries, total)
+	listing.Items = listing.Items[start:end]
+	listing.Truncated, listing.Cursor = end < total, ""
+	if listing.Truncated {
+		listing.Cursor = encodeSortCursor(listing.Items[len(listing.Items)-1])
+	}
+}
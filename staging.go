@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// promotedMetadataKey marks staged objects that were promoted but couldn't be
+// deleted, typically because of a retention policy or hold on the bucket, so
+// that they are not promoted again.
+const promotedMetadataKey = "gcs-index-promoted"
+
+// promotingMetadataKey marks the .noindex markers hiding directories while they
+// are promoted, to tell them apart from those of bucket owners.
+const promotingMetadataKey = "gcs-index-promoting"
+
+// checkStaging validates the staging prefix of a mount point, relative to its
+// path.
+func checkStaging(staging string) error {
+	if staging == "" {
+		return nil
+	}
+	if !strings.HasSuffix(staging, "/") || checkPath(&url.URL{Path: "/" + staging}) != nil {
+		return fmt.Errorf("invalid staging prefix %q", staging)
+	}
+	return nil
+}
+
+// stagingName returns the name uploads to the object are staged under, which
+// is the name itself on mount points without staging.
+func (m *MountPoint) stagingName(name string) string {
+	if m.Staging == "" || m.isStaged(name) {
+		return name
+	}
+	return m.Prefix + m.Staging + strings.TrimPrefix(name, m.Prefix)
+}
+
+// publicName returns the name a staged object is promoted to.
+func (m *MountPoint) publicName(name string) string {
+	if !m.isStaged(name) {
+		return name
+	}
+	return m.Prefix + strings.TrimPrefix(name, m.Prefix+m.Staging)
+}
+
+// isStaged tells whether the object is in the staging area, which is hidden
+// from listings and requests.
+func (m *MountPoint) isStaged(name string) bool {
+	return m.Staging != "" && strings.HasPrefix(name, m.Prefix+m.Staging)
+}
+
+func stagedObject(mountPoint *MountPoint, obj *storage.ObjectHandle) *storage.ObjectHandle {
+	return client.Bucket(obj.BucketName()).Object(mountPoint.stagingName(obj.ObjectName()))
+}
+
+// promote rewrites the staged uploads at the request path, an object or a
+// whole directory, to their public names. Every object appears at once, and a
+// directory, which is promoted one object at a time, is hidden from listings
+// until all of its objects are.
+func promote(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "promote")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil || mountPoint.Staging == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	bucket := client.Bucket(mountPoint.Bucket)
+	staged, err := stagedObjects(ctx, bucket, mountPoint.stagingName(name), strings.HasSuffix(r.URL.Path, "/"))
+	if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to list staged objects", "bucket", mountPoint.Bucket, "prefix", mountPoint.stagingName(name), "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	} else if len(staged) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Refuse the whole promotion rather than publishing part of it.
+	for _, attrs := range staged {
+		if !checkWriteOnce(w, r, mountPoint, bucket.Object(mountPoint.publicName(attrs.Name))) {
+			return
+		}
+	}
+
+	var marker *storage.ObjectHandle
+	if strings.HasSuffix(r.URL.Path, "/") {
+		var stagedMarker = mountPoint.stagingName(name + noIndexMarker)
+		if i := slices.IndexFunc(staged, func(attrs *storage.ObjectAttrs) bool { return attrs.Name == stagedMarker }); i >= 0 {
+			// The directory is meant to stay hidden, from the start then.
+			staged[0], staged[i] = staged[i], staged[0]
+		} else if marker, err = hideDirectory(ctx, bucket, name); err != nil {
+			span.RecordError(err)
+			slog.Error("failed to hide directory", "bucket", mountPoint.Bucket, "prefix", name, "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}
+
+	var promoted []objectAttrsResponse
+	for _, attrs := range staged {
+		src := bucket.Object(attrs.Name).Generation(attrs.Generation)
+		dst := bucket.Object(mountPoint.publicName(attrs.Name))
+		result, err := writeTarget(mountPoint, dst).CopierFrom(src).Run(ctx)
+		if err != nil && len(promoted) == 0 && marker != nil {
+			// Nothing to hide yet.
+			showDirectory(ctx, marker)
+		}
+		if isPreconditionFailed(err) && len(promoted) == 0 {
+			writeConflict(w, r, dst)
+			return
+		} else if isPreconditionFailed(err) {
+			slog.Warn("refusing to overwrite object", "bucket", dst.BucketName(), "object", dst.ObjectName(), "promoted", len(promoted))
+			writePartialPromotion(w, http.StatusConflict, "object already exists", dst, promoted)
+			return
+		} else if err != nil {
+			span.RecordError(err)
+			slog.Error("failed to promote object", "bucket", dst.BucketName(), "object", dst.ObjectName(), "promoted", len(promoted), "err", err)
+			writePartialPromotion(w, http.StatusBadGateway, "failed to promote object", dst, promoted)
+			return
+		}
+		audit(r, "promote", "bucket", result.Bucket, "stagedObject", attrs.Name, "object", result.Name, "generation", result.Generation)
+		promoted = append(promoted, newObjectAttrsResponse(result))
+		discardStaged(ctx, src, result.Generation)
+	}
+
+	if marker != nil && !showDirectory(ctx, marker) {
+		writePartialPromotion(w, http.StatusBadGateway, "failed to remove .noindex marker", marker, promoted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(promoted)
+}
+
+// hideDirectory writes a .noindex marker into the directory, so that it isn't
+// listed while it is promoted. It returns nil if the directory is hidden by its
+// owner already, and adopts the marker left by a promotion that failed.
+func hideDirectory(ctx context.Context, bucket *storage.BucketHandle, dir string) (*storage.ObjectHandle, error) {
+	marker := bucket.Object(dir + noIndexMarker)
+	writer := marker.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	writer.Metadata = map[string]string{promotingMetadataKey: "true"}
+	if err := writer.Close(); isPreconditionFailed(err) {
+		attrs, err := marker.Attrs(ctx)
+		if err != nil {
+			return nil, err
+		} else if attrs.Metadata[promotingMetadataKey] == "" {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	noIndexMarkers.Forget(marker.BucketName() + "/" + marker.ObjectName())
+	return marker, nil
+}
+
+// showDirectory removes the marker written by hideDirectory, returning false if
+// it failed.
+func showDirectory(ctx context.Context, marker *storage.ObjectHandle) bool {
+	if err := marker.Delete(ctx); err != nil {
+		slog.Error("failed to remove noindex marker", "bucket", marker.BucketName(), "object", marker.ObjectName(), "err", err)
+		return false
+	}
+	noIndexMarkers.Forget(marker.BucketName() + "/" + marker.ObjectName())
+	return true
+}
+
+// writePartialPromotion answers a promotion that failed on the given object,
+// listing the objects promoted before it, which stay hidden from listings with
+// the rest of their directory.
+func writePartialPromotion(w http.ResponseWriter, status int, message string, failed *storage.ObjectHandle, promoted []objectAttrsResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":    message,
+		"name":     failed.ObjectName(),
+		"promoted": promoted,
+	})
+}
+
+// stagedObjects returns the staged object with the given name, or the staged
+// objects below it for directories, leaving out those already promoted.
+func stagedObjects(ctx context.Context, bucket *storage.BucketHandle, name string, dir bool) ([]*storage.ObjectAttrs, error) {
+	var staged []*storage.ObjectAttrs
+	if !dir {
+		attrs, err := bucket.Object(name).Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if attrs.Metadata[promotedMetadataKey] == "" {
+			staged = append(staged, attrs)
+		}
+		return staged, nil
+	}
+
+	objects := bucket.Objects(ctx, &storage.Query{Prefix: name})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return staged, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if attrs.Metadata[promotedMetadataKey] == "" {
+			staged = append(staged, attrs)
+		}
+	}
+}
+
+// discardStaged deletes a promoted object from the staging area. Objects under
+// retention can't be deleted yet, they are marked as promoted instead.
+func discardStaged(ctx context.Context, obj *storage.ObjectHandle, generation int64) {
+	err := obj.Delete(ctx)
+	if err == nil {
+		return
+	}
+	slog.Warn("failed to delete staged object, marking it as promoted", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+	update := storage.ObjectAttrsToUpdate{Metadata: map[string]string{promotedMetadataKey: strconv.FormatInt(generation, 10)}}
+	if _, err := obj.Update(ctx, update); err != nil {
+		slog.Error("failed to mark staged object as promoted", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// statusMetadataKey holds the comma-separated statuses of an artifact, or of a
+// version directory on its placeholder object, e.g. qa-passed,promoted. They
+// are shown as badges in HTML listings.
+const statusMetadataKey = "gcs-index-status"
+
+// yankedStatus marks known-bad builds, which ?exclude-yanked leaves out.
+const yankedStatus = "yanked"
+
+var statusRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// parseStatus reads the statuses of object metadata, comma-separated without
+// the malformed ones.
+func parseStatus(metadata map[string]string) string {
+	var statuses []string
+	for _, status := range strings.Split(metadata[statusMetadataKey], ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if statusRegexp.MatchString(status) {
+			statuses = append(statuses, status)
+		}
+	}
+	return strings.Join(statuses, ",")
+}
+
+// hasStatus tells whether statuses read by parseStatus hold one.
+func hasStatus(statuses, status string) bool {
+	return slices.Contains(strings.Split(statuses, ","), status)
+}
+
+// statusBadges splits statuses read by parseStatus, for HTML listings.
+func statusBadges(statuses string) []string {
+	if statuses == "" {
+		return nil
+	}
+	return strings.Split(statuses, ",")
+}
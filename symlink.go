@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// redirectMetadataKey makes an object a link: requests for it are redirected
+// to the URL or path in its value, relative to the directory of the object,
+// e.g. to keep current.tar.gz pointing at the latest build.
+const redirectMetadataKey = "gcs-index-redirect"
+
+// redirectStatusMetadataKey optionally sets the status of the redirect of a
+// link, 302 by default.
+const redirectStatusMetadataKey = "gcs-index-redirect-status"
+
+// redirectLink redirects to the target of a link object, returning false if
+// the object isn't one.
+func redirectLink(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint, metadata map[string]string) bool {
+	var target = metadata[redirectMetadataKey]
+	if target == "" {
+		return false
+	}
+	ref, err := url.Parse(target)
+	if err != nil {
+		slog.Error("invalid link target", "path", r.URL.Path, "target", target, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	}
+	var status = http.StatusFound
+	if value := metadata[redirectStatusMetadataKey]; value != "" {
+		switch status, _ = strconv.Atoi(value); status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			slog.Error("invalid link status", "path", r.URL.Path, "status", value)
+			w.WriteHeader(http.StatusBadGateway)
+			return true
+		}
+	}
+
+	if !ref.IsAbs() {
+		ref = (&url.URL{Path: r.URL.Path}).ResolveReference(ref)
+		target = linksFor(r).Absolute(ref.Path)
+		if ref.RawQuery != "" {
+			target += "?" + ref.RawQuery
+		}
+	}
+	w.Header().Set("Location", target)
+	w.Header().Set("Cache-Control", mountPoint.CacheControl)
+	w.WriteHeader(status)
+	return true
+}
@@ -0,0 +1,70 @@
+(function () {
+    var head = document.querySelector("thead.columns");
+    var filter = document.querySelector("p.filter");
+    if (!head || !filter) {
+        return;
+    }
+    // Directories and files may be split into tables, each sorted on its own.
+    var tables = head.closest("table").parentNode.querySelectorAll(":scope > table");
+    var column = -1, descending = false;
+
+    function entries(table) {
+        var rows = table.tBodies.length ? table.tBodies[0].rows : [];
+        return Array.prototype.filter.call(rows, function (row) {
+            return !row.classList.contains("parent");
+        });
+    }
+
+    // Raw values of data-sort are numbers, names are compared naturally.
+    function value(row) {
+        var cell = row.cells[column];
+        if (!cell) {
+            return null;
+        }
+        return cell.dataset.sort !== undefined ? Number(cell.dataset.sort) : cell.textContent;
+    }
+
+    function compare(a, b) {
+        var x = value(a), y = value(b), result;
+        if (x === null || y === null) {
+            result = (x === null ? 0 : 1) - (y === null ? 0 : 1);
+        } else if (typeof x === "number") {
+            result = x - y;
+        } else {
+            result = x.localeCompare(y, undefined, {numeric: true});
+        }
+        return descending ? -result : result;
+    }
+
+    head.addEventListener("click", function (event) {
+        var button = event.target.closest("button");
+        if (!button) {
+            return;
+        }
+        var clicked = Number(button.dataset.column);
+        descending = clicked === column && !descending;
+        column = clicked;
+        head.querySelectorAll("th").forEach(function (th) {
+            th.removeAttribute("aria-sort");
+        });
+        button.parentNode.setAttribute("aria-sort", descending ? "descending" : "ascending");
+        tables.forEach(function (table) {
+            entries(table).sort(compare).forEach(function (row) {
+                row.parentNode.appendChild(row);
+            });
+        });
+    });
+
+    var input = filter.querySelector("input");
+    input.addEventListener("input", function () {
+        var query = input.value.toLowerCase();
+        tables.forEach(function (table) {
+            entries(table).forEach(function (row) {
+                row.hidden = row.cells[0].textContent.toLowerCase().indexOf(query) < 0;
+            });
+        });
+    });
+
+    head.hidden = false;
+    filter.hidden = false;
+})();
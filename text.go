@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+const textContentType = "text/plain"
+
+// textFormat renders one entry per line for shell scripts, directories with a
+// trailing slash. Long listings add tab-separated size and modification time
+// columns, empty for directories but for the size with ?du.
+func textFormat(long bool) ListingFormat {
+	return ListingFormat{ContentType: textContentType + "; charset=utf-8", Render: func(ctx context.Context, w *bytes.Buffer, listing *Listing) {
+		for _, item := range listing.Items {
+			if !long {
+				w.WriteString(item.Name + "\n")
+			} else if item.Dir && listing.DiskUsage {
+				fmt.Fprintf(w, "%s\t%d\t\n", item.Name, item.Size)
+			} else if item.Dir {
+				w.WriteString(item.Name + "\t\t\n")
+			} else {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", item.Name, item.Size, item.Updated.UTC().Format(time.RFC3339))
+			}
+		}
+	}}
+}
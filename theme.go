@@ -0,0 +1,59 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// themeScript switches HTML listings between light and dark, remembering the
+// choice of the browser's user.
+//
+//go:embed theme.js
+var themeScript string
+
+// extraCSS is the content of -extra-css, appended to the styles of HTML
+// listings.
+var extraCSS template.CSS
+
+// setupTheme reads -extra-css and lets the default Content-Security-Policy
+// load -css-url, along with the fonts next to it.
+func setupTheme() error {
+	if *extraCSSFile != "" {
+		css, err := os.ReadFile(*extraCSSFile)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(strings.ToLower(string(css)), "</style") {
+			return fmt.Errorf("%s: unexpected </style>", *extraCSSFile)
+		}
+		extraCSS = template.CSS(css)
+	}
+
+	if *cssURL == "" {
+		return nil
+	}
+	u, err := url.Parse(*cssURL)
+	if err != nil {
+		return err
+	}
+	var source = "'self'"
+	if u.IsAbs() {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("stylesheet URL %q is not http or https", *cssURL)
+		}
+		source = u.Scheme + "://" + u.Host
+	}
+	var directives = strings.Split(defaultSecurityHeaders.Get("Content-Security-Policy"), "; ")
+	for i, directive := range directives {
+		if strings.HasPrefix(directive, "style-src ") {
+			directives[i] += " " + source
+		}
+	}
+	directives = append(directives, "font-src "+source)
+	defaultSecurityHeaders.Set("Content-Security-Policy", strings.Join(directives, "; "))
+	return nil
+}
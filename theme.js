@@ -0,0 +1,20 @@
+(function () {
+    var root = document.documentElement;
+    try {
+        root.dataset.theme = localStorage.getItem("gcs-index-theme") || "";
+    } catch (e) {}
+    document.addEventListener("DOMContentLoaded", function () {
+        var toggle = document.querySelector(".theme");
+        if (!toggle) {
+            return;
+        }
+        toggle.hidden = false;
+        toggle.addEventListener("click", function () {
+            var dark = root.dataset.theme ? root.dataset.theme === "dark" : matchMedia("(prefers-color-scheme: dark)").matches;
+            root.dataset.theme = dark ? "light" : "dark";
+            try {
+                localStorage.setItem("gcs-index-theme", root.dataset.theme);
+            } catch (e) {}
+        });
+    });
+})();
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var tracer = otel.Tracer("github.com/tipee-sa/gcs-index")
+
+// setupTracing installs an OTLP/HTTP trace exporter if an endpoint is set,
+// either with -otlp-endpoint or the standard OTEL_EXPORTER_OTLP_* variables.
+// Without one, the global no-op tracer provider stays in place.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+
+	if *otlpEndpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return
+	}
+
+	var opts []otlptracehttp.Option
+	if *otlpEndpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(*otlpEndpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	slog.Info("tracing enabled", "endpoint", *otlpEndpoint)
+
+	return provider.Shutdown, nil
+}
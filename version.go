@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/hashicorp/go-version"
 )
@@ -13,6 +17,19 @@ var versionRegexp = regexp.MustCompile(
 		`(\+([0-9A-Za-z\-~]+(\.[0-9A-Za-z\-~]+)*))?`,
 )
 
+// Schemes of versions for version sort.
+const (
+	semverScheme = "semver"
+	calverScheme = "calver"
+)
+
+func checkVersionScheme(scheme string) error {
+	if scheme != semverScheme && scheme != calverScheme {
+		return fmt.Errorf("invalid version scheme %q", scheme)
+	}
+	return nil
+}
+
 func guessVersion(name string) (*version.Version, int) {
 	loc := versionRegexp.FindStringIndex(name)
 	if loc == nil {
@@ -26,3 +43,64 @@ func guessVersion(name string) (*version.Version, int) {
 
 	return ver, loc[0]
 }
+
+// calverRegexp finds date-based versions: a year, possibly followed by the
+// month and day without separators, then numbers with separators, such as
+// 2024.05.2, 2024-05-31 or 20240531.1.
+var calverRegexp = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2}(?:[0-9]{2}){0,2}(?:[.\-_][0-9]+)*)(?:[^0-9]|$)`)
+
+// guessCalVer returns the numbers of the date-based version of a name, and
+// where it starts.
+func guessCalVer(name string) ([]int, int) {
+	loc := calverRegexp.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return nil, 0
+	}
+
+	var parts = strings.FieldsFunc(name[loc[2]:loc[3]], func(r rune) bool {
+		return r == '.' || r == '-' || r == '_'
+	})
+	// Compact dates hold the month and day right after the year.
+	year, _ := strconv.Atoi(parts[0][:4])
+	var numbers = []int{year}
+	for i := 4; i < len(parts[0]); i += 2 {
+		n, _ := strconv.Atoi(parts[0][i : i+2])
+		numbers = append(numbers, n)
+	}
+	for _, part := range parts[1:] {
+		n, _ := strconv.Atoi(part)
+		numbers = append(numbers, n)
+	}
+	return numbers, loc[2]
+}
+
+// compareVersions compares the versions of two names, and returns where they
+// start; ok is false unless both have one. CalVer names are compared with
+// others, and with equal dates, as semver ones: 2024.05.2-rc1 comes before
+// 2024.05.2.
+func compareVersions(a, b, scheme string) (result, i, j int, ok bool) {
+	if scheme == calverScheme {
+		if ca, i := guessCalVer(a); ca != nil {
+			if cb, j := guessCalVer(b); cb != nil && !slices.Equal(ca, cb) {
+				return slices.Compare(ca, cb), i, j, true
+			}
+		}
+	}
+	va, i := guessVersion(a)
+	vb, j := guessVersion(b)
+	if va == nil || vb == nil {
+		return 0, 0, 0, false
+	}
+	return va.Compare(vb), i, j, true
+}
+
+// prereleaseRegexp matches the pre-release components of unstable versions.
+// Others, such as the platform in 1.2.0-linux-amd64, are taken for releases.
+var prereleaseRegexp = regexp.MustCompile(`(?i)^(alpha|beta|dev|nightly|pre|preview|rc|snapshot)([0-9.\-]|$)`)
+
+// isPrerelease tells whether the guessed version of a name has a pre-release
+// component, such as 1.2.0-rc1 or 2.0.0-beta.
+func isPrerelease(name string) bool {
+	ver, _ := guessVersion(strings.TrimSuffix(name, "/"))
+	return ver != nil && prereleaseRegexp.MatchString(ver.Prerelease())
+}
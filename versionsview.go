@@ -0,0 +1,95 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// versionsView groups the entries of HTML listings by the version in their
+// names, for directories holding the artifacts of every version side by side.
+const versionsView = "versions"
+
+// versionGroup returns the version grouping an entry and its label: the
+// release, along with a pre-release component such as rc1 but not a platform
+// as in 1.2.0-linux-amd64. Numbers without a dot aren't taken for versions.
+func versionGroup(name string) (*version.Version, string) {
+	ver, _ := guessVersion(strings.TrimSuffix(name, "/"))
+	if ver == nil {
+		return nil, ""
+	}
+	var label, _, _ = strings.Cut(ver.Original(), "-")
+	label, _, _ = strings.Cut(label, "+")
+	if !strings.Contains(label, ".") {
+		return nil, ""
+	}
+	var group = ver.Core()
+	if pre, _, _ := strings.Cut(ver.Prerelease(), "-"); prereleaseRegexp.MatchString(pre) {
+		label += "-" + pre
+		group, _ = version.NewVersion(group.String() + "-" + pre)
+	}
+	return group, label
+}
+
+// groupByVersion orders entries by version, newest first, and heads each
+// version with its label; entries without a version come last, under other.
+func groupByVersion(entries []htmlEntry, other string) []htmlEntry {
+	type group struct {
+		version *version.Version
+		entries []htmlEntry
+	}
+	var groups []*group
+	var byVersion = make(map[string]*group)
+	var others []htmlEntry
+	for _, entry := range entries {
+		entry.Split = false
+		ver, label := versionGroup(entry.Name)
+		if ver == nil {
+			others = append(others, entry)
+			continue
+		}
+		var g = byVersion[ver.String()]
+		if g == nil {
+			entry.Group = label
+			g = &group{version: ver}
+			byVersion[ver.String()] = g
+			groups = append(groups, g)
+		}
+		g.entries = append(g.entries, entry)
+	}
+	if len(groups) == 0 {
+		return entries
+	}
+
+	slices.SortStableFunc(groups, func(a, b *group) int {
+		return b.version.Compare(a.version)
+	})
+	var result = make([]htmlEntry, 0, len(entries))
+	for _, g := range groups {
+		result = append(result, g.entries...)
+	}
+	if len(others) > 0 {
+		others[0].Group = other
+	}
+	return append(result, others...)
+}
+
+// versionsLink links to the versions view of a directory, when some of its
+// entries have a version.
+func versionsLink(listing *Listing, query url.Values) {
+	if listing.view == versionsView || listing.mountPoint == nil || listing.Recursive {
+		return
+	}
+	if !slices.ContainsFunc(listing.Items, func(item Item) bool {
+		ver, _ := versionGroup(item.Name)
+		return ver != nil
+	}) {
+		return
+	}
+	var values = maps.Clone(query)
+	values.Set("view", versionsView)
+	listing.versions = "?" + values.Encode()
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+const maxPatchSize = 64 * 1024
+
+// Object metadata is served as response headers, writers can't set those
+// which would change how responses are handled, nor the keys of gcs-index.
+var (
+	reservedMetadataPrefixes = []string{"access-control-", "content-", "cross-origin-", "gcs-index-", "proxy-", "sec-", "x-goog-"}
+	reservedMetadataKeys     = []string{"age", "alt-svc", "cache-control", "clear-site-data", "connection", "date", "etag", "expires", "keep-alive", "last-modified", "link", "location", "permissions-policy", "referrer-policy", "refresh", "retry-after", "server", "set-cookie", "set-cookie2", "strict-transport-security", "te", "trailer", "transfer-encoding", "upgrade", "vary", "www-authenticate", "x-content-type-options", "x-fetched-at", "x-frame-options", "x-index-generation", "x-robots-tag"}
+	metadataKeyRegexp        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+)
+
+// checkMetadataKey refuses metadata keys that aren't plain header names, or
+// that are reserved.
+func checkMetadataKey(key string) error {
+	var lower = strings.ToLower(key)
+	if !metadataKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid metadata key %q", key)
+	}
+	if slices.Contains(reservedMetadataKeys, lower) || slices.ContainsFunc(reservedMetadataPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(lower, prefix)
+	}) {
+		return fmt.Errorf("reserved metadata key %q", key)
+	}
+	return nil
+}
+
+// objectPatch is a JSON merge patch (RFC 7396) of the editable object
+// attributes. Null members reset the attribute; null metadata entries are
+// cleared, which hides them from responses.
+type objectPatch struct {
+	ContentType        patchString        `json:"contentType"`
+	ContentEncoding    patchString        `json:"contentEncoding"`
+	ContentLanguage    patchString        `json:"contentLanguage"`
+	ContentDisposition patchString        `json:"contentDisposition"`
+	CacheControl       patchString        `json:"cacheControl"`
+	Metadata           map[string]*string `json:"metadata"`
+}
+
+// patchString is a string member of a merge patch, either absent, null or set.
+type patchString struct {
+	Set   bool
+	Value string
+}
+
+func (p *patchString) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &p.Value)
+}
+
+// objectAttrsResponse describes an object after a write.
+type objectAttrsResponse struct {
+	Name               string            `json:"name"`
+	Generation         int64             `json:"generation"`
+	Metageneration     int64             `json:"metageneration"`
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+// handlePatch updates the attributes of an object on a writable mount point,
+// leaving its content untouched.
+func handlePatch(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handlePatch")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil || strings.HasSuffix(r.URL.Path, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if mediaType := r.Header.Get("Content-Type"); !strings.HasPrefix(mediaType, "application/merge-patch+json") && !strings.HasPrefix(mediaType, "application/json") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var patch objectPatch
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		slog.Warn("invalid patch", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var update storage.ObjectAttrsToUpdate
+	if patch.ContentType.Set {
+		update.ContentType = patch.ContentType.Value
+	}
+	if patch.ContentEncoding.Set {
+		update.ContentEncoding = patch.ContentEncoding.Value
+	}
+	if patch.ContentLanguage.Set {
+		update.ContentLanguage = patch.ContentLanguage.Value
+	}
+	if patch.ContentDisposition.Set {
+		update.ContentDisposition = patch.ContentDisposition.Value
+	}
+	if patch.CacheControl.Set {
+		update.CacheControl = patch.CacheControl.Value
+	}
+	if len(patch.Metadata) > 0 {
+		update.Metadata = make(map[string]string, len(patch.Metadata))
+		for k, v := range patch.Metadata {
+			if err := checkMetadataKey(k); err != nil {
+				slog.Warn("invalid patch", "path", r.URL.Path, "err", err)
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, err.Error()+"\n")
+				return
+			}
+			if v != nil {
+				update.Metadata[k] = *v
+			} else {
+				update.Metadata[k] = ""
+			}
+		}
+	}
+
+	obj := client.Bucket(mountPoint.Bucket).Object(name)
+	attrs, err := obj.Update(ctx, update)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to update object", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	audit(r, "patch", "bucket", attrs.Bucket, "object", attrs.Name, "metageneration", attrs.Metageneration)
+	writeObjectAttrs(w, http.StatusOK, attrs)
+}
+
+func writeObjectAttrs(w http.ResponseWriter, status int, attrs *storage.ObjectAttrs) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newObjectAttrsResponse(attrs))
+}
+
+func newObjectAttrsResponse(attrs *storage.ObjectAttrs) objectAttrsResponse {
+	return objectAttrsResponse{
+		Name:               attrs.Name,
+		Generation:         attrs.Generation,
+		Metageneration:     attrs.Metageneration,
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentDisposition: attrs.ContentDisposition,
+		CacheControl:       attrs.CacheControl,
+		Metadata:           attrs.Metadata,
+	}
+}
+
+// audit logs a change made through gcs-index along with who made it.
+func audit(r *http.Request, action string, args ...any) {
+	slog.Info("audit", append([]any{"action", action, "user", requestUser(r), "remote", r.RemoteAddr}, args...)...)
+}
+
+// handlePost runs server-side actions writing to an object of a writable
+// mount point.
+func handlePost(w http.ResponseWriter, r *http.Request) {
+	switch action := r.URL.Query().Get("action"); action {
+	case "copy", "move":
+		copyObject(w, r, r.URL.Query().Get("source"), action == "move")
+	case "compose":
+		composeObject(w, r)
+	case "sign-upload":
+		signUpload(w, r)
+	case "promote":
+		promote(w, r)
+	case "prime":
+		prime(w, r)
+	case "approve-delete", "reject-delete":
+		reviewDelete(w, r, action == "approve-delete")
+	case "yank", "unyank":
+		yank(w, r, action == "yank")
+	default:
+		slog.Warn("unknown action", "path", r.URL.Path, "action", action)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// copyObject copies the object at the source path to the request path with a
+// server-side rewrite, possibly across buckets. Moves then delete the source,
+// which must be on a writable mount point too.
+func copyObject(w http.ResponseWriter, r *http.Request, source string, move bool) {
+	ctx, span := tracer.Start(r.Context(), "copyObject")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil || strings.HasSuffix(r.URL.Path, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !checkName(w, mountPoint, r.URL.Path) {
+		return
+	}
+
+	var sourceURL = &url.URL{Path: source}
+	if err := checkPath(sourceURL); err != nil || strings.HasSuffix(source, "/") {
+		slog.Warn("invalid source", "source", source, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var sourceMountPoint, sourceName = resolvePath(ctx, source)
+	if sourceMountPoint == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if move && (!sourceMountPoint.Writable || sourceMountPoint.WriteOnce) {
+		slog.Warn("source of move is not writable", "source", source)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if move && sourceMountPoint.DeleteApproval != nil {
+		slog.Warn("source of move requires delete approval", "source", source)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if sourceMountPoint.Bucket == mountPoint.Bucket && sourceName == name {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The credentials must grant access to the source as well.
+	var sourceRequest = r.Clone(ctx)
+	sourceRequest.URL = sourceURL
+	if _, ok := authenticate(w, sourceRequest, sourceMountPoint); !ok {
+		return
+	}
+
+	src := client.Bucket(sourceMountPoint.Bucket).Object(sourceName)
+	srcAttrs, err := src.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to get object attributes", "bucket", src.BucketName(), "object", src.ObjectName(), "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	// Pin the generation, so that a move never deletes what it didn't copy.
+	src = src.Generation(srcAttrs.Generation)
+	dst := client.Bucket(mountPoint.Bucket).Object(name)
+	if !checkWriteOnce(w, r, mountPoint, dst) {
+		return
+	}
+
+	// The copy is server-side, so the content is read once for the scanner.
+	if mountPoint.Scanner != nil && !sameScanner(sourceMountPoint.Scanner, mountPoint.Scanner) {
+		reader, err := src.NewReader(ctx)
+		if err == nil {
+			err = mountPoint.Scanner.Scan(ctx, r.URL.Path, reader)
+			reader.Close()
+		}
+		if errors.Is(err, errInfected) {
+			audit(r, "copy-rejected", "sourceBucket", src.BucketName(), "sourceObject", src.ObjectName(), "bucket", dst.BucketName(), "object", dst.ObjectName(), "reason", err)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, err.Error()+"\n")
+			return
+		} else if err != nil {
+			span.RecordError(err)
+			slog.Error("failed to scan source", "source", source, "path", r.URL.Path, "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}
+	attrs, err := writeTarget(mountPoint, stagedObject(mountPoint, dst)).CopierFrom(src).Run(ctx)
+	if isPreconditionFailed(err) {
+		writeConflict(w, r, dst)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to copy object", "source", source, "bucket", dst.BucketName(), "object", dst.ObjectName(), "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	var action = "copy"
+	if move {
+		action = "move"
+		if err := src.Delete(ctx); err != nil {
+			span.RecordError(err)
+			slog.Error("failed to delete source of move", "bucket", src.BucketName(), "object", src.ObjectName(), "err", err)
+			audit(r, "copy", "sourceBucket", src.BucketName(), "sourceObject", src.ObjectName(), "bucket", attrs.Bucket, "object", attrs.Name, "generation", attrs.Generation)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}
+
+	audit(r, action, "sourceBucket", src.BucketName(), "sourceObject", src.ObjectName(), "bucket", attrs.Bucket, "object", attrs.Name, "generation", attrs.Generation)
+	w.Header().Set("Location", r.URL.Path)
+	writeObjectAttrs(w, http.StatusCreated, attrs)
+}
+
+// bodyReader records the errors reading a request body, to tell them apart
+// from those writing it elsewhere.
+type bodyReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// rejectUpload answers 422 with the reason the scanner rejected an upload.
+func rejectUpload(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint, name string, reason error) {
+	audit(r, "put-rejected", "bucket", mountPoint.Bucket, "object", name, "reason", reason)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	io.WriteString(w, reason.Error()+"\n")
+}
+
+// handlePut uploads the request body to an object of a writable mount point.
+// A Content-MD5 header has GCS check the integrity of the upload.
+func handlePut(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handlePut")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil || strings.HasSuffix(r.URL.Path, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !checkName(w, mountPoint, r.URL.Path) {
+		return
+	}
+
+	// Cancelling the context aborts the upload.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	obj := client.Bucket(mountPoint.Bucket).Object(name)
+	if !checkWriteOnce(w, r, mountPoint, obj) {
+		return
+	}
+	writer := writeTarget(mountPoint, stagedObject(mountPoint, obj)).NewWriter(writeCtx)
+	writer.ContentType = r.Header.Get("Content-Type")
+	writer.ContentEncoding = r.Header.Get("Content-Encoding")
+	writer.ContentLanguage = r.Header.Get("Content-Language")
+	writer.ContentDisposition = r.Header.Get("Content-Disposition")
+	writer.CacheControl = r.Header.Get("Cache-Control")
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writer.MD5 = sum
+	}
+
+	// The content is scanned while it is uploaded, the object is only created
+	// once the scanner accepted it.
+	var output io.Writer = writer
+	var scanWriter *io.PipeWriter
+	var verdict <-chan error
+	if mountPoint.Scanner != nil {
+		scanWriter, verdict = startScan(ctx, mountPoint.Scanner, r.URL.Path)
+		output = io.MultiWriter(writer, scanWriter)
+	}
+
+	// Errors reading the body are the client's, others GCS's or the scanner's.
+	var body = &bodyReader{reader: r.Body}
+	if _, err := io.Copy(output, body); err != nil {
+		cancel()
+		if scanWriter != nil {
+			scanWriter.CloseWithError(err)
+		}
+		span.RecordError(err)
+		if body.err != nil {
+			slog.Warn("upload aborted", "path", r.URL.Path, "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Infected content is rejected as such, even if GCS failed first.
+		if scanWriter != nil {
+			if result := <-verdict; errors.Is(result, errInfected) {
+				rejectUpload(w, r, mountPoint, name, result)
+				return
+			}
+		}
+		slog.Error("failed to upload object", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	if scanWriter != nil {
+		scanWriter.Close()
+		if err := <-verdict; errors.Is(err, errInfected) {
+			cancel()
+			rejectUpload(w, r, mountPoint, name, err)
+			return
+		} else if err != nil {
+			cancel()
+			span.RecordError(err)
+			slog.Error("failed to scan upload", "path", r.URL.Path, "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+	}
+
+	if err := writer.Close(); isPreconditionFailed(err) {
+		writeConflict(w, r, obj)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to upload object", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	attrs := writer.Attrs()
+	audit(r, "put", "bucket", attrs.Bucket, "object", attrs.Name, "generation", attrs.Generation, "size", attrs.Size)
+	w.Header().Set("Location", r.URL.Path)
+	writeObjectAttrs(w, http.StatusCreated, attrs)
+}
+
+// composeRequest lists the paths of the parts to concatenate, in order.
+type composeRequest struct {
+	Sources       []string `json:"sources"`
+	DeleteSources bool     `json:"deleteSources"`
+}
+
+// composeObject concatenates objects of the same bucket into the object at
+// the request path, server-side. GCS composes at most 32 objects at once, so
+// longer lists go through intermediate objects.
+func composeObject(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "composeObject")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil || strings.HasSuffix(r.URL.Path, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !checkName(w, mountPoint, r.URL.Path) {
+		return
+	}
+
+	if mountPoint.Scanner != nil {
+		slog.Warn("compose refused on scanned mount point", "path", r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var request composeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil || len(request.Sources) == 0 {
+		slog.Warn("invalid compose request", "path", r.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bucket := client.Bucket(mountPoint.Bucket)
+	var sources, deletable []*storage.ObjectHandle
+	for _, source := range request.Sources {
+		var sourceURL = &url.URL{Path: source}
+		if err := checkPath(sourceURL); err != nil || strings.HasSuffix(source, "/") {
+			slog.Warn("invalid source", "source", source, "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sourceMountPoint, sourceName := resolvePath(ctx, source)
+		if sourceMountPoint == nil || !sourceMountPoint.Writable || sourceMountPoint.Bucket != mountPoint.Bucket {
+			slog.Warn("sources must be on writable mount points of the same bucket", "source", source)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var sourceRequest = r.Clone(ctx)
+		sourceRequest.URL = sourceURL
+		if _, ok := authenticate(w, sourceRequest, sourceMountPoint); !ok {
+			return
+		}
+		sources = append(sources, stagedObject(sourceMountPoint, bucket.Object(sourceName)))
+		if !sourceMountPoint.WriteOnce && sourceMountPoint.DeleteApproval == nil {
+			deletable = append(deletable, sources[len(sources)-1])
+		}
+	}
+
+	dst := bucket.Object(name)
+	if !checkWriteOnce(w, r, mountPoint, dst) {
+		return
+	}
+	attrs, err := compose(ctx, writeTarget(mountPoint, stagedObject(mountPoint, dst)), sources)
+	if isPreconditionFailed(err) {
+		writeConflict(w, r, dst)
+		return
+	} else if errors.Is(err, storage.ErrObjectNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to compose object", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	audit(r, "compose", "bucket", attrs.Bucket, "object", attrs.Name, "generation", attrs.Generation, "size", attrs.Size, "sources", len(sources))
+
+	// Sources on write-once mount points are kept.
+	if request.DeleteSources {
+		for _, source := range deletable {
+			if err := source.Delete(ctx); err != nil {
+				slog.Warn("failed to delete source of compose", "bucket", source.BucketName(), "object", source.ObjectName(), "err", err)
+			}
+		}
+	}
+
+	w.Header().Set("Location", r.URL.Path)
+	writeObjectAttrs(w, http.StatusCreated, attrs)
+}
+
+const maxComposeSources = 32
+
+func compose(ctx context.Context, dst *storage.ObjectHandle, sources []*storage.ObjectHandle) (*storage.ObjectAttrs, error) {
+	bucket := client.Bucket(dst.BucketName())
+	var intermediates []*storage.ObjectHandle
+	defer func() {
+		for _, obj := range intermediates {
+			if err := obj.Delete(context.WithoutCancel(ctx)); err != nil {
+				slog.Warn("failed to delete intermediate object", "object", obj.ObjectName(), "err", err)
+			}
+		}
+	}()
+
+	// Intermediate objects get unique names next to the destination, in the
+	// staging area if any, and never replace an existing object.
+	var id = randomID()
+	for len(sources) > maxComposeSources {
+		intermediate := bucket.Object(fmt.Sprintf("%s.compose-%s-%d", dst.ObjectName(), id, len(intermediates)))
+		if _, err := intermediate.If(storage.Conditions{DoesNotExist: true}).ComposerFrom(sources[:maxComposeSources]...).Run(ctx); err != nil {
+			return nil, err
+		}
+		intermediates = append(intermediates, intermediate)
+		sources = append([]*storage.ObjectHandle{intermediate}, sources[maxComposeSources:]...)
+	}
+
+	return dst.ComposerFrom(sources...).Run(ctx)
+}
+
+// signedUpload tells a client how to upload an object straight to GCS.
+type signedUpload struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Expires time.Time         `json:"expires"`
+}
+
+// signUpload issues a signed URL to upload the object at the request path, so
+// that large uploads don't go through this process. The content type, if
+// given, is part of the signature.
+func signUpload(w http.ResponseWriter, r *http.Request) {
+	var mountPoint, name = resolvePath(r.Context(), r.URL.Path)
+	if mountPoint == nil || strings.HasSuffix(r.URL.Path, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !checkName(w, mountPoint, r.URL.Path) {
+		return
+	}
+
+	if mountPoint.Scanner != nil {
+		slog.Warn("signed upload refused on scanned mount point", "path", r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var upload = signedUpload{Method: http.MethodPut, Expires: time.Now().Add(*signedURLTTL).UTC()}
+	var options = &storage.SignedURLOptions{
+		Method:  http.MethodPut,
+		Expires: upload.Expires,
+		Scheme:  storage.SigningSchemeV4,
+	}
+	name = mountPoint.stagingName(name)
+	if mountPoint.WriteOnce && !mountPoint.isStaged(name) {
+		// GCS enforces the precondition when the client sends the header.
+		options.Headers = []string{"x-goog-if-generation-match:0"}
+		upload.Headers = map[string]string{"X-Goog-If-Generation-Match": "0"}
+	}
+	if contentType := r.URL.Query().Get("contentType"); contentType != "" {
+		options.ContentType = contentType
+		if upload.Headers == nil {
+			upload.Headers = make(map[string]string)
+		}
+		upload.Headers["Content-Type"] = contentType
+	}
+
+	var err error
+	if upload.URL, err = client.Bucket(mountPoint.Bucket).SignedURL(name, options); err != nil {
+		slog.Error("failed to sign url", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit(r, "sign-upload", "bucket", mountPoint.Bucket, "object", name, "expires", upload.Expires)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(upload)
+}
+
+// writeTarget adds the preconditions of the mount point to an object about to
+// be written. Staged objects can be written again until they are promoted.
+func writeTarget(mountPoint *MountPoint, obj *storage.ObjectHandle) *storage.ObjectHandle {
+	if mountPoint.WriteOnce && !mountPoint.isStaged(obj.ObjectName()) {
+		return obj.If(storage.Conditions{DoesNotExist: true})
+	}
+	return obj
+}
+
+// checkWriteOnce answers with a conflict if the object already exists on a
+// write-once mount point, before any content is transferred. The precondition
+// of writeTarget still covers races.
+func checkWriteOnce(w http.ResponseWriter, r *http.Request, mountPoint *MountPoint, obj *storage.ObjectHandle) bool {
+	if !mountPoint.WriteOnce {
+		return true
+	}
+	if _, err := obj.Attrs(r.Context()); errors.Is(err, storage.ErrObjectNotExist) {
+		return true
+	} else if err != nil {
+		slog.Error("failed to get object attributes", "bucket", obj.BucketName(), "object", obj.ObjectName(), "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return false
+	}
+	writeConflict(w, r, obj)
+	return false
+}
+
+// writeConflict answers with the fingerprint of the existing object, so that
+// clients can tell whether it is the content they meant to publish.
+func writeConflict(w http.ResponseWriter, r *http.Request, obj *storage.ObjectHandle) {
+	slog.Warn("refusing to overwrite object", "bucket", obj.BucketName(), "object", obj.ObjectName())
+	var conflict = map[string]any{"error": "object already exists"}
+	if attrs, err := obj.Attrs(r.Context()); err == nil {
+		conflict["name"] = attrs.Name
+		conflict["generation"] = attrs.Generation
+		conflict["size"] = attrs.Size
+		conflict["md5"] = fmt.Sprintf("%x", attrs.MD5)
+		conflict["crc32c"] = fmt.Sprintf("%08x", attrs.CRC32C)
+		conflict["updated"] = attrs.Updated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(conflict)
+}
+
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// yankReasonMetadataKey explains why an object was yanked, to those who
+// request it anyway.
+const yankReasonMetadataKey = "gcs-index-yank-reason"
+
+// yank marks the object at the request path with the yanked status, along with
+// the reason parameter, or unmarks it. The content is kept, but the object is
+// left out of listings and gone for direct requests until it is unyanked.
+func yank(w http.ResponseWriter, r *http.Request, yanked bool) {
+	ctx, span := tracer.Start(r.Context(), "yank")
+	defer span.End()
+
+	var mountPoint, name = resolvePath(ctx, r.URL.Path)
+	if mountPoint == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	obj := client.Bucket(mountPoint.Bucket).Object(name)
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to get object attributes", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	// Other statuses are kept, empty metadata entries are cleared.
+	var statuses = slices.DeleteFunc(statusBadges(parseStatus(attrs.Metadata)), func(status string) bool {
+		return status == yankedStatus
+	})
+	var reason string
+	if yanked {
+		statuses = append(statuses, yankedStatus)
+		reason = r.URL.Query().Get("reason")
+	}
+	var update = storage.ObjectAttrsToUpdate{Metadata: map[string]string{
+		statusMetadataKey:     strings.Join(statuses, ","),
+		yankReasonMetadataKey: reason,
+	}}
+	attrs, err = obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration}).Update(ctx, update)
+	if isPreconditionFailed(err) {
+		slog.Warn("object changed while yanking", "bucket", mountPoint.Bucket, "object", name)
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		span.RecordError(err)
+		slog.Error("failed to update object", "bucket", mountPoint.Bucket, "object", name, "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	var action = "unyank"
+	if yanked {
+		action = "yank"
+	}
+	audit(r, action, "bucket", attrs.Bucket, "object", attrs.Name, "metageneration", attrs.Metageneration, "reason", reason)
+	writeObjectAttrs(w, http.StatusOK, attrs)
+}
+
+// serveYanked answers 410 Gone with the reason for yanked objects, returning
+// false for others.
+func serveYanked(w http.ResponseWriter, mountPoint *MountPoint, metadata map[string]string) bool {
+	if !hasStatus(parseStatus(metadata), yankedStatus) {
+		return false
+	}
+	var message = "This artifact was yanked"
+	if reason := metadata[yankReasonMetadataKey]; reason != "" {
+		message += ": " + reason
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", mountPoint.CacheControl)
+	w.WriteHeader(http.StatusGone)
+	io.WriteString(w, message+"\n")
+	return true
+}